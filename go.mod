@@ -1,3 +1,3 @@
 module go-hashmap
 
-go 1.17
+go 1.19
@@ -1,34 +1,374 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"fmt"
+	"math"
+	"math/bits"
 	"math/rand"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	DEFAULT_HASH_MAP_SIZE = 1 << 10
-	DEFAULT_LOAD_FACTOR   = 0.75
+	DEFAULT_HASH_MAP_SIZE  = 1 << 10
+	DEFAULT_LOAD_FACTOR    = 0.75
+	DEFAULT_SHRINK_RATIO   = 0.25 // shrink when load factor drops below loadFactor * DEFAULT_SHRINK_RATIO
+	DEFAULT_EVACUATE_COUNT = 2
 )
 
-type HashValue struct {
-	k int
-	v int
+// Ordered is the key constraint of the tree backends, every key must support < and ==
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 |
+		~string
+}
+
+type HashValue[K comparable, V any] struct {
+	k K
+	v V
+}
+
+// Hasher maps a key to a hash index in [0, l)
+type Hasher[K comparable] interface {
+	Hash(K, uint) int
+}
+
+// MAX_HASHER_SIZE is the length handed to a Hasher for a hash of many bits, the largest power of two a uint holds on every GOARCH
+const MAX_HASHER_SIZE = 1 << 31
+
+// HasherFunc adapts an ordinary function to a Hasher
+type HasherFunc[K comparable] func(K, uint) int
+
+func (f HasherFunc[K]) Hash(k K, l uint) int {
+	return f(k, l)
 }
 
 func defaultHashFunc(k int, l uint) int {
 	return k & int((l - 1))
 }
 
-type HashMapData interface {
+// defaultHasher masks integer keys like defaultHashFunc and runs FNV-1a over everything else
+type defaultHasher[K comparable] struct{}
+
+func (defaultHasher[K]) Hash(k K, l uint) int {
+	return defaultHashFunc(int(hash64(k)), l)
+}
+
+const (
+	FNV_OFFSET_64 = 14695981039346656037
+	FNV_PRIME_64  = 1099511628211
+)
+
+// hash64 returns integer keys as they are, pointers and channels by address and FNV-1a of everything else
+func hash64[K comparable](k K) uint64 {
+	// fast path without reflect for the common key types
+	switch key := any(k).(type) {
+	case int:
+		return uint64(key)
+	case int64:
+		return uint64(key)
+	case uint64:
+		return key
+	case string:
+		return fnvString(FNV_OFFSET_64, key)
+	}
+	value := reflect.ValueOf(k)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint()
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		// 按地址区分，指向相等内容的两个指针是不同的键
+		return uint64(value.Pointer())
+	case reflect.String:
+		return fnvString(FNV_OFFSET_64, value.String())
+	}
+	return fnvValue(FNV_OFFSET_64, value)
+}
+
+// fnvUint64 feeds the 8 bytes of x to FNV-1a, low byte first
+func fnvUint64(h uint64, x uint64) uint64 {
+	for index := 0; index != 8; index++ {
+		h ^= x & 0xff
+		h *= FNV_PRIME_64
+		x >>= 8
+	}
+	return h
+}
+
+func fnvString(h uint64, s string) uint64 {
+	for index := 0; index != len(s); index++ {
+		h ^= uint64(s[index])
+		h *= FNV_PRIME_64
+	}
+	return h
+}
+
+func fnvFloat(h uint64, f float64) uint64 {
+	if f == 0 { // +0 == -0
+		f = 0
+	}
+	return fnvUint64(h, math.Float64bits(f))
+}
+
+// fnvValue walks value with reflect and feeds it to FNV-1a, values equal under == feed the same bytes
+func fnvValue(h uint64, value reflect.Value) uint64 {
+	switch value.Kind() {
+	case reflect.Invalid: // nil interface
+		return fnvUint64(h, 0)
+	case reflect.Bool:
+		if value.Bool() {
+			return fnvUint64(h, 1)
+		}
+		return fnvUint64(h, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fnvUint64(h, uint64(value.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fnvUint64(h, value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return fnvFloat(h, value.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := value.Complex()
+		return fnvFloat(fnvFloat(h, real(c)), imag(c))
+	case reflect.String:
+		// the length keeps {"ab", "c"} and {"a", "bc"} apart
+		return fnvString(fnvUint64(h, uint64(value.Len())), value.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return fnvUint64(h, uint64(value.Pointer()))
+	case reflect.Interface:
+		if value.IsNil() {
+			return fnvUint64(h, 0)
+		}
+		elem := value.Elem()
+		return fnvValue(fnvUint64(h, uint64(elem.Kind())), elem)
+	case reflect.Array:
+		for index := 0; index != value.Len(); index++ {
+			h = fnvValue(h, value.Index(index))
+		}
+		return h
+	case reflect.Struct:
+		valueType := value.Type()
+		for index := 0; index != value.NumField(); index++ {
+			if valueType.Field(index).Name == "_" { // == skips blank fields
+				continue
+			}
+			h = fnvValue(h, value.Field(index))
+		}
+		return h
+	}
+	// func, map and slice are not comparable and never reach here
+	return h
+}
+
+// dynamicHash mixes hash64 of the key, or a custom hasher over MAX_HASHER_SIZE if hasher is not nil,
+// dynamic hashing takes as many bits of it as its bucket count needs
+func dynamicHash[K comparable](hasher Hasher[K], k K) uint64 {
+	if hasher == nil {
+		return mix64(hash64(k))
+	}
+	return mix64(uint64(hasher.Hash(k, MAX_HASHER_SIZE)))
+}
+
+// customHasher returns nil for defaultHasher, which would only keep the low bits of hash64
+func customHasher[K comparable](hasher Hasher[K]) Hasher[K] {
+	if _, ok := hasher.(defaultHasher[K]); ok {
+		return nil
+	}
+	return hasher
+}
+
+// mix64 is the splitmix64 finalizer, it spreads every input bit over the output
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+type HashMapData[K comparable, V any] interface {
 	Len() int
-	Get(int, int) (int, bool)
-	Set(int, *HashValue) bool
-	Del(int, int) (int, bool)
-	Range(func(*HashValue) bool)
-	Reallocate(uint)
+	Get(int, K) (V, bool)
+	Set(int, *HashValue[K, V]) bool
+	Del(int, K) (V, bool)
+	Range(func(*HashValue[K, V]) bool)
+	// Reallocate resizes to the given length and rehashes every value with the hasher,
+	// it returns false and keeps the origin data if any value can not be placed
+	Reallocate(uint, Hasher[K]) bool
+	// Allocate returns an empty data structure of the same kind with the given length
+	Allocate(uint) HashMapData[K, V]
+	// Evacuate removes every value stored at the hash index and hands them to op one by one
+	Evacuate(int, func(*HashValue[K, V]))
+	// Iterator walks every value like Range, but one value per call
+	Iterator() HashMapDataIterator[K, V]
+}
+
+// HashMapDataIterator returns the next value on every call, and nil after the last one
+type HashMapDataIterator[K comparable, V any] interface {
+	Next() *HashValue[K, V]
+}
+
+// sliceIterator walks the slots of an array, value returns nil for an empty slot
+type sliceIterator[K comparable, V any] struct {
+	length int
+	index  int
+	value  func(int) *HashValue[K, V]
+}
+
+func (i *sliceIterator[K, V]) Next() *HashValue[K, V] {
+	for i.index < i.length {
+		hashValue := i.value(i.index)
+		i.index++
+		if hashValue != nil {
+			return hashValue
+		}
+	}
+	return nil
+}
+
+// bucketIterator walks the buckets of an array, next returns the values of one bucket one by one and nil at its end
+type bucketIterator[K comparable, V any] struct {
+	length int
+	index  int
+	next   func(int) *HashValue[K, V]
+}
+
+func (i *bucketIterator[K, V]) Next() *HashValue[K, V] {
+	for ; i.index < i.length; i.index++ {
+		if hashValue := i.next(i.index); hashValue != nil {
+			return hashValue
+		}
+	}
+	return nil
+}
+
+type treeIteratorFrame[N any] struct {
+	node     N
+	position int // values before position are visited
+}
+
+// treeIterator walks the trees of buckets in order with an explicit stack instead of recursion,
+// child returns the child before the position-th value, value returns nil once position is out of the node
+type treeIterator[K comparable, V any, N comparable] struct {
+	buckets []N
+	index   int
+	stack   []treeIteratorFrame[N]
+	child   func(N, int) N
+	value   func(N, int) *HashValue[K, V]
+}
+
+func (i *treeIterator[K, V, N]) pushLeftmost(node N) {
+	var null N
+	for ; node != null; node = i.child(node, 0) {
+		i.stack = append(i.stack, treeIteratorFrame[N]{node: node})
+	}
+}
+
+func (i *treeIterator[K, V, N]) Next() *HashValue[K, V] {
+	for {
+		if len(i.stack) == 0 {
+			if i.index == len(i.buckets) {
+				return nil
+			}
+			i.pushLeftmost(i.buckets[i.index])
+			i.index++
+			continue
+		}
+		frame := &i.stack[len(i.stack)-1]
+		hashValue := i.value(frame.node, frame.position)
+		if hashValue == nil {
+			i.stack = i.stack[:len(i.stack)-1]
+			continue
+		}
+		frame.position++
+		i.pushLeftmost(i.child(frame.node, frame.position))
+		return hashValue
+	}
+}
+
+// chainIterator walks iterators one after another
+type chainIterator[K comparable, V any] struct {
+	iterators []HashMapDataIterator[K, V]
+}
+
+func (i *chainIterator[K, V]) Next() *HashValue[K, V] {
+	for len(i.iterators) > 0 {
+		if hashValue := i.iterators[0].Next(); hashValue != nil {
+			return hashValue
+		}
+		i.iterators = i.iterators[1:]
+	}
+	return nil
+}
+
+// tombstoneCounter is implemented by open address data structures which leave tombstones on Del
+type tombstoneCounter interface {
+	Tombstones() int
+}
+
+// snapshotter is implemented by data structures which share their buckets with a read only snapshot,
+// afterwards a write copies the bucket array once and every bucket it touches, the snapshot is never written
+type snapshotter[K comparable, V any] interface {
+	Snapshot() HashMapData[K, V]
+}
+
+// copyOnWrite tracks the buckets shared with snapshots,
+// Del looks the key up before owning the bucket so that a missing key copies nothing
+type copyOnWrite struct {
+	shared bool   // the bucket array itself is shared
+	owned  []bool // buckets copied since the last snapshot, nil if no snapshot is taken
+}
+
+func (c *copyOnWrite) share() {
+	c.shared, c.owned = true, nil
+}
+
+// ownBucket makes buckets[index] writable, it returns the bucket array which may be a new copy
+func ownBucket[N any](c *copyOnWrite, buckets []N, index int, clone func(N) N) []N {
+	if c.shared {
+		buckets = append([]N(nil), buckets...)
+		c.shared, c.owned = false, make([]bool, len(buckets))
+	}
+	if c.owned != nil && !c.owned[index] {
+		buckets[index] = clone(buckets[index])
+		c.owned[index] = true
+	}
+	return buckets
+}
+
+// selfResizer is implemented by dynamic hashing data structures which grow and shrink a bucket at a time,
+// HashMap never resizes them by load factor but hands its load factor over, and its hasher by Reallocate
+type selfResizer interface {
+	SetLoadFactor(float64)
+}
+
+// mutatingGetter is implemented by data structures whose Get restructures them like splay tree buckets,
+// such Get needs exclusive access just like Set and Del
+type mutatingGetter interface {
+	MutatesOnGet()
+}
+
+// reallocate re-inserts every value visited by rangeFunc into to with the hasher
+func reallocate[K comparable, V any](rangeFunc func(func(*HashValue[K, V]) bool), to HashMapData[K, V], hasher Hasher[K]) bool {
+	size := uint(to.Len())
+	ok := true
+	rangeFunc(func(hashValue *HashValue[K, V]) bool {
+		hashIndex := hasher.Hash(hashValue.k, size)
+		ok = 0 <= hashIndex && hashIndex < int(size) && to.Set(hashIndex, hashValue)
+		return ok
+	})
+	return ok
 }
 
 // ----------------------------------------------------------------
@@ -39,50 +379,101 @@ type HashMapData interface {
 
 // liner detection and hashing, awful but works...
 
-type ldhHashMapData struct {
-	array []*HashValue
+type ldhHashMapData[K comparable, V any] struct {
+	array      []*HashValue[K, V]
+	tombstone  *HashValue[K, V] // marks deleted slot, probing goes on over it but stops at nil
+	tombstones int
 }
 
-func (d *ldhHashMapData) Len() int {
+func (d *ldhHashMapData[K, V]) Len() int {
 	return len(d.array)
 }
 
-func (d *ldhHashMapData) get(hashIndex, key int, op func(int) (int, bool)) (int, bool) {
-	for index := hashIndex; index < len(d.array); index++ {
-		if d.array[index] != nil && d.array[index].k == key {
+func (d *ldhHashMapData[K, V]) Tombstones() int {
+	return d.tombstones
+}
+
+func (d *ldhHashMapData[K, V]) isTombstone(index int) bool {
+	return d.array[index] != nil && d.array[index] == d.tombstone
+}
+
+// probe returns the step-th slot from hash index, wrapping around so len(d.array) steps visit every slot
+func (d *ldhHashMapData[K, V]) probe(hashIndex, step int) int {
+	return (hashIndex + step) % len(d.array)
+}
+
+func (d *ldhHashMapData[K, V]) get(hashIndex int, key K, op func(int) (V, bool)) (V, bool) {
+	for step := 0; step != len(d.array); step++ {
+		index := d.probe(hashIndex, step)
+		if d.array[index] == nil {
+			break
+		}
+		if !d.isTombstone(index) && d.array[index].k == key {
 			return op(index)
 		}
 	}
-	return 0, false
+	return *new(V), false
 }
 
-func (d *ldhHashMapData) Set(hashIndex int, hashValue *HashValue) bool {
-	for index := hashIndex; index != len(d.array); index++ {
-		if d.array[index] == nil || d.array[index].k == hashValue.k {
+func (d *ldhHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	reuseIndex := -1
+	for step := 0; step != len(d.array); step++ {
+		index := d.probe(hashIndex, step)
+		if d.array[index] == nil {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+			break
+		}
+		if d.isTombstone(index) {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+		} else if d.array[index].k == hashValue.k {
 			d.array[index] = hashValue
 			return true
 		}
 	}
-	return false
+	if reuseIndex == -1 {
+		return false
+	}
+	if d.isTombstone(reuseIndex) {
+		d.tombstones--
+	}
+	d.array[reuseIndex] = hashValue
+	return true
 }
 
-func (d *ldhHashMapData) Get(hashIndex, key int) (int, bool) {
-	return d.get(hashIndex, key, func(index int) (int, bool) {
+func (d *ldhHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
 		return d.array[index].v, true
 	})
 }
 
-func (d *ldhHashMapData) Del(hashIndex, key int) (int, bool) {
-	return d.get(hashIndex, key, func(index int) (int, bool) {
+func (d *ldhHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
 		value := d.array[index].v
-		d.array[index] = nil
+		d.bury(index)
 		return value, true
 	})
 }
 
-func (d *ldhHashMapData) Range(op func(*HashValue) bool) {
-	for _, hashValue := range d.array {
-		if hashValue == nil {
+// bury leaves a tombstone at index, unless no probe can pass through it
+func (d *ldhHashMapData[K, V]) bury(index int) {
+	if d.array[d.probe(index, 1)] == nil {
+		d.array[index] = nil
+		return
+	}
+	if d.tombstone == nil {
+		d.tombstone = &HashValue[K, V]{}
+	}
+	d.array[index] = d.tombstone
+	d.tombstones++
+}
+
+func (d *ldhHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for index, hashValue := range d.array {
+		if hashValue == nil || d.isTombstone(index) {
 			continue
 		}
 		if !op(hashValue) {
@@ -91,70 +482,140 @@ func (d *ldhHashMapData) Range(op func(*HashValue) bool) {
 	}
 }
 
-func (d *ldhHashMapData) Reallocate(size uint) {
-	if uint(len(d.array)) != size {
-		d.array = make([]*HashValue, size)
+// Reallocate rebuilds the array even at the same size, which clears every tombstone
+func (d *ldhHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := &ldhHashMapData[K, V]{
+		array: make([]*HashValue[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.array, d.tombstones = newData.array, 0
+	return true
+}
+
+func (d *ldhHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &ldhHashMapData[K, V]{
+		array: make([]*HashValue[K, V], size),
+	}
+}
+
+func (d *ldhHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	if hashValue := d.array[hashIndex]; hashValue != nil && !d.isTombstone(hashIndex) {
+		d.bury(hashIndex)
+		op(hashValue)
+	}
+}
+
+func (d *ldhHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	array, tombstone := d.array, d.tombstone
+	return &sliceIterator[K, V]{
+		length: len(array),
+		value: func(index int) *HashValue[K, V] {
+			if array[index] == tombstone {
+				return nil
+			}
+			return array[index]
+		},
 	}
-	// TODO: move data
 }
 
 // second detection and hashing SDH
 
 // second detection and hashing is nearly shit...
 
-type sdhHashMapData struct {
-	array []*HashValue
+type sdhHashMapData[K comparable, V any] struct {
+	array      []*HashValue[K, V]
+	tombstone  *HashValue[K, V] // marks deleted slot, probing goes on over it but stops at nil
+	tombstones int
 }
 
-func (d *sdhHashMapData) Len() int {
+func (d *sdhHashMapData[K, V]) Len() int {
 	return len(d.array)
 }
 
-func (d *sdhHashMapData) get(hashIndex, key int, op func(int) (int, bool)) (int, bool) {
-	for index := 1; index <= len(d.array)/2; index++ {
-		lIndex := hashIndex - index*index
-		rIndex := hashIndex + index*index
-		if lIndex >= 0 && d.array[lIndex] != nil && d.array[lIndex].k == key {
-			return op(lIndex)
-		} else if rIndex < len(d.array) && d.array[rIndex] != nil && d.array[rIndex].k == key {
-			return op(rIndex)
+func (d *sdhHashMapData[K, V]) Tombstones() int {
+	return d.tombstones
+}
+
+func (d *sdhHashMapData[K, V]) isTombstone(index int) bool {
+	return d.array[index] != nil && d.array[index] == d.tombstone
+}
+
+// probe returns the step-th slot from hash index by triangular numbers 0, 1, 3, 6, 10...,
+// len(d.array) steps visit every slot since allocate keeps len(d.array) a power of two
+func (d *sdhHashMapData[K, V]) probe(hashIndex, step int) int {
+	return (hashIndex + step*(step+1)/2) % len(d.array)
+}
+
+func (d *sdhHashMapData[K, V]) get(hashIndex int, key K, op func(int) (V, bool)) (V, bool) {
+	for step := 0; step != len(d.array); step++ {
+		index := d.probe(hashIndex, step)
+		if d.array[index] == nil {
+			break
+		}
+		if !d.isTombstone(index) && d.array[index].k == key {
+			return op(index)
 		}
 	}
-	return 0, false
+	return *new(V), false
 }
 
-func (d *sdhHashMapData) Set(hashIndex int, hashValue *HashValue) bool {
-	for index := 1; index <= len(d.array)/2; index++ {
-		lIndex := hashIndex - index*index
-		rIndex := hashIndex + index*index
-		if lIndex >= 0 && (d.array[lIndex] == nil || d.array[lIndex].k == hashValue.k) {
-			d.array[lIndex] = hashValue
-			return true
-		} else if rIndex < len(d.array) && (d.array[rIndex] == nil || d.array[rIndex].k == hashValue.k) {
-			d.array[rIndex] = hashValue
+func (d *sdhHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	reuseIndex := -1
+	for step := 0; step != len(d.array); step++ {
+		index := d.probe(hashIndex, step)
+		if d.array[index] == nil {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+			break
+		}
+		if d.isTombstone(index) {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+		} else if d.array[index].k == hashValue.k {
+			d.array[index] = hashValue
 			return true
 		}
 	}
-	return false
+	if reuseIndex == -1 {
+		return false
+	}
+	if d.isTombstone(reuseIndex) {
+		d.tombstones--
+	}
+	d.array[reuseIndex] = hashValue
+	return true
 }
 
-func (d *sdhHashMapData) Get(hashIndex, key int) (int, bool) {
-	return d.get(hashIndex, key, func(index int) (int, bool) {
+func (d *sdhHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
 		return d.array[index].v, true
 	})
 }
 
-func (d *sdhHashMapData) Del(hashIndex, key int) (int, bool) {
-	return d.get(hashIndex, key, func(index int) (int, bool) {
+func (d *sdhHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
 		value := d.array[index].v
-		d.array[index] = nil
+		d.bury(index)
 		return value, true
 	})
 }
 
-func (d *sdhHashMapData) Range(op func(*HashValue) bool) {
-	for _, hashValue := range d.array {
-		if hashValue == nil {
+// bury leaves a tombstone at index, the probe sequences passing through it are unknown
+func (d *sdhHashMapData[K, V]) bury(index int) {
+	if d.tombstone == nil {
+		d.tombstone = &HashValue[K, V]{}
+	}
+	d.array[index] = d.tombstone
+	d.tombstones++
+}
+
+func (d *sdhHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for index, hashValue := range d.array {
+		if hashValue == nil || d.isTombstone(index) {
 			continue
 		}
 		if !op(hashValue) {
@@ -163,1680 +624,5656 @@ func (d *sdhHashMapData) Range(op func(*HashValue) bool) {
 	}
 }
 
-func (d *sdhHashMapData) Reallocate(size uint) {
-	if uint(len(d.array)) != size {
-		d.array = make([]*HashValue, size)
+// Reallocate rebuilds the array even at the same size, which clears every tombstone,
+// the size is rounded up to a power of two like Allocate
+func (d *sdhHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := d.allocate(size)
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
 	}
-	// TODO: move data
+	d.array, d.tombstones = newData.array, 0
+	return true
 }
 
-// random detection and hashing
+// allocate rounds the size up to a power of two, the only lengths for which probe visits every slot
+func (d *sdhHashMapData[K, V]) allocate(size uint) *sdhHashMapData[K, V] {
+	return &sdhHashMapData[K, V]{
+		array: make([]*HashValue[K, V], 1<<bits.Len(size-1)),
+	}
+}
 
-// random detection and hashing is a shit...
+func (d *sdhHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
 
-// chain address collision
+func (d *sdhHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	if hashValue := d.array[hashIndex]; hashValue != nil && !d.isTombstone(hashIndex) {
+		d.bury(hashIndex)
+		op(hashValue)
+	}
+}
 
-// doubly linked list - DLL
+func (d *sdhHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	array, tombstone := d.array, d.tombstone
+	return &sliceIterator[K, V]{
+		length: len(array),
+		value: func(index int) *HashValue[K, V] {
+			if array[index] == tombstone {
+				return nil
+			}
+			return array[index]
+		},
+	}
+}
+
+// robin hood hashing RHH
 
-type dllNode struct {
-	nextNode *dllNode
-	preNode  *dllNode
-	value    *HashValue
+// linear probing, but the entry far from its home steals the slot from the rich one
+
+type robinHoodSlot[K comparable, V any] struct {
+	value    *HashValue[K, V]
+	distance int // probe distance from home slot
 }
 
-type dllHashMapData struct {
-	buckets []*dllNode
+type robinHoodHashMapData[K comparable, V any] struct {
+	slots []robinHoodSlot[K, V]
+	count int
 }
 
-func (d *dllHashMapData) Len() int {
-	return len(d.buckets)
+func (d *robinHoodHashMapData[K, V]) Len() int {
+	return len(d.slots)
 }
 
-func (d *dllHashMapData) Get(hashIndex, key int) (int, bool) {
-	for p := d.buckets[hashIndex]; p != nil; p = p.nextNode {
-		if p.value != nil && p.value.k == key {
-			return p.value.v, true
-		}
-	}
-	return 0, false
+func (d *robinHoodHashMapData[K, V]) next(index int) int {
+	return (index + 1) % len(d.slots)
 }
 
-func (d *dllHashMapData) Set(hashIndex int, hashValue *HashValue) bool {
-	var preNode *dllNode
-	for p := d.buckets[hashIndex]; p != nil; p = p.nextNode {
-		if p.value.k == hashValue.k {
-			p.value = hashValue
-			return true
-		} else {
-			preNode = p
+// find stops at the first empty slot or the first slot richer than key would be
+func (d *robinHoodHashMapData[K, V]) find(hashIndex int, key K) int {
+	index := hashIndex
+	for distance := 0; distance != len(d.slots); distance++ {
+		slot := &d.slots[index]
+		if slot.value == nil || slot.distance < distance {
+			return -1
 		}
+		if slot.value.k == key {
+			return index
+		}
+		index = d.next(index)
 	}
-	vNode := &dllNode{
-		value: hashValue,
-	}
-	if preNode == nil {
-		d.buckets[hashIndex] = vNode
-	} else {
-		preNode.nextNode = vNode
-		vNode.preNode = preNode
-	}
-	return true
+	return -1
 }
 
-func (d *dllHashMapData) Del(hashIndex, key int) (int, bool) {
-	for p := d.buckets[hashIndex]; p != nil; p = p.nextNode {
-		if p.value != nil && p.value.k == key {
-			value := p.value.v
-			p.value = nil
-			if p.preNode == nil { // bucket head
-				if p.nextNode == nil {
-					d.buckets[hashIndex] = nil
-				} else {
-					d.buckets[hashIndex] = p.nextNode
-					d.buckets[hashIndex].preNode = nil
-					p.preNode = nil
-					p.nextNode = nil
-				}
-			} else if p.nextNode == nil { // bucket tail
-				p.preNode.nextNode = nil
-				p.preNode = nil
-				p.nextNode = nil
-			} else { // bucket middle
-				p.preNode.nextNode = p.nextNode
-				p.nextNode.preNode = p.preNode
-				p.preNode = nil
-				p.nextNode = nil
-			}
-			return value, true
-		}
+func (d *robinHoodHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if index := d.find(hashIndex, key); index != -1 {
+		return d.slots[index].value.v, true
 	}
-	return 0, false
+	return *new(V), false
 }
 
-func (d *dllHashMapData) Range(op func(*HashValue) bool) {
-	for _, bucket := range d.buckets {
-		for node := bucket; node != nil; node = node.nextNode {
-			if !op(node.value) {
-				return
-			}
+func (d *robinHoodHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	if index := d.find(hashIndex, hashValue.k); index != -1 {
+		d.slots[index].value = hashValue
+		return true
+	}
+	if d.count == len(d.slots) {
+		return false
+	}
+	index, insert := hashIndex, robinHoodSlot[K, V]{value: hashValue}
+	for {
+		slot := &d.slots[index]
+		if slot.value == nil {
+			*slot = insert
+			d.count++
+			return true
 		}
+		if slot.distance < insert.distance {
+			*slot, insert = insert, *slot
+		}
+		index = d.next(index)
+		insert.distance++
 	}
 }
 
-func (d *dllHashMapData) Reallocate(size uint) {
-	if uint(len(d.buckets)) != size {
-		d.buckets = make([]*dllNode, size)
+func (d *robinHoodHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	index := d.find(hashIndex, key)
+	if index == -1 {
+		return *new(V), false
 	}
-	// TODO: move data
+	value := d.slots[index].value.v
+	d.shift(index)
+	return value, true
 }
 
-// binary search tree - BST
+// shift 后移删除：后续槽位依次前移一格，直到遇到空槽或者已在本位的数据
+func (d *robinHoodHashMapData[K, V]) shift(index int) {
+	for next := d.next(index); d.slots[next].value != nil && d.slots[next].distance > 0; index, next = next, d.next(next) {
+		d.slots[index] = d.slots[next]
+		d.slots[index].distance--
+	}
+	d.slots[index] = robinHoodSlot[K, V]{}
+	d.count--
+}
 
-type bstNode struct {
-	leftChild  *bstNode
-	rightChild *bstNode
-	value      *HashValue
+func (d *robinHoodHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, slot := range d.slots {
+		if slot.value == nil {
+			continue
+		}
+		if !op(slot.value) {
+			return
+		}
+	}
 }
 
-func (n *bstNode) preOrderTraversal(op func(*HashValue) bool, deep int) bool {
-	fmt.Printf("%v", strings.Repeat("\t", deep))
-	if !op(n.value) {
-		return false
+func (d *robinHoodHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.slots)) == size {
+		return true
 	}
-	if n.leftChild != nil {
-		n.leftChild.preOrderTraversal(op, deep+1)
+	newData := &robinHoodHashMapData[K, V]{
+		slots: make([]robinHoodSlot[K, V], size),
 	}
-	if n.rightChild != nil {
-		n.rightChild.preOrderTraversal(op, deep+1)
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
 	}
+	d.slots, d.count = newData.slots, newData.count
 	return true
 }
 
-func (n *bstNode) inOrderTraversal(op func(*HashValue) bool) bool {
-	if n.leftChild != nil {
-		n.leftChild.inOrderTraversal(op)
-	}
-	if !op(n.value) {
-		return false
+func (d *robinHoodHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &robinHoodHashMapData[K, V]{
+		slots: make([]robinHoodSlot[K, V], size),
+	}
+}
+
+// Evacuate keeps taking the slot until it is empty, so no entry shifts back behind the evacuate index
+func (d *robinHoodHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	for d.slots[hashIndex].value != nil {
+		hashValue := d.slots[hashIndex].value
+		d.shift(hashIndex)
+		op(hashValue)
+	}
+}
+
+func (d *robinHoodHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	slots := d.slots
+	return &sliceIterator[K, V]{
+		length: len(slots),
+		value: func(index int) *HashValue[K, V] {
+			return slots[index].value
+		},
+	}
+}
+
+// cuckoo hashing
+
+// two tables, every key lives at its primary index of table 0 or its secondary index of table 1
+
+const (
+	CUCKOO_MAX_KICK   = 32 // evictions before an insert is considered cycling
+	CUCKOO_STASH_SIZE = 4  // homeless entries kept aside before asking for a rehash
+)
+
+type cuckooSlot[K comparable, V any] struct {
+	value     *HashValue[K, V]
+	hashIndex int // primary index, the backend can not hash with the map's hasher
+}
+
+// cuckooHashMapData owns its secondary hash, Set returns false when an insert cycles
+// and the stash is full, HashMap then grows and rehashes everything
+type cuckooHashMapData[K comparable, V any] struct {
+	tables [2][]cuckooSlot[K, V]
+	stash  []cuckooSlot[K, V]
+	seed   uint64 // secondary hash seed, changed on every Reallocate
+}
+
+func (d *cuckooHashMapData[K, V]) Len() int {
+	return len(d.tables[0])
+}
+
+func (d *cuckooHashMapData[K, V]) secondIndex(key K) int {
+	return int(mix64(hash64(key)^d.seed) % uint64(len(d.tables[1])))
+}
+
+// find returns the slot holding key, table 2 means the stash
+func (d *cuckooHashMapData[K, V]) find(hashIndex int, key K) (int, int) {
+	if slot := d.tables[0][hashIndex]; slot.value != nil && slot.value.k == key {
+		return 0, hashIndex
+	}
+	if index := d.secondIndex(key); d.tables[1][index].value != nil && d.tables[1][index].value.k == key {
+		return 1, index
+	}
+	for index, slot := range d.stash {
+		if slot.value.k == key {
+			return 2, index
+		}
+	}
+	return -1, -1
+}
+
+func (d *cuckooHashMapData[K, V]) slot(table, index int) *cuckooSlot[K, V] {
+	if table == 2 {
+		return &d.stash[index]
+	}
+	return &d.tables[table][index]
+}
+
+func (d *cuckooHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if table, index := d.find(hashIndex, key); table != -1 {
+		return d.slot(table, index).value.v, true
+	}
+	return *new(V), false
+}
+
+func (d *cuckooHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	if table, index := d.find(hashIndex, hashValue.k); table != -1 {
+		d.slot(table, index).value = hashValue
+		return true
+	}
+	insert := cuckooSlot[K, V]{value: hashValue, hashIndex: hashIndex}
+	if d.tables[0][hashIndex].value == nil {
+		d.tables[0][hashIndex] = insert
+		return true
+	}
+	if index := d.secondIndex(hashValue.k); d.tables[1][index].value == nil {
+		d.tables[1][index] = insert
+		return true
+	}
+
+	// kick the occupant to its other table until someone finds an empty slot
+	type move struct{ table, index int }
+	moves := make([]move, 0, CUCKOO_MAX_KICK)
+	table, index := 0, hashIndex
+	for kick := 0; kick != CUCKOO_MAX_KICK; kick++ {
+		moves = append(moves, move{table, index})
+		d.tables[table][index], insert = insert, d.tables[table][index]
+		if insert.value == nil {
+			return true
+		}
+		if table == 0 {
+			table, index = 1, d.secondIndex(insert.value.k)
+		} else {
+			table, index = 0, insert.hashIndex
+		}
+	}
+	if len(d.stash) < CUCKOO_STASH_SIZE {
+		d.stash = append(d.stash, insert)
+		return true
+	}
+
+	// cycling, undo every kick so nothing is lost and let HashMap rehash
+	for step := len(moves) - 1; step >= 0; step-- {
+		m := moves[step]
+		d.tables[m.table][m.index], insert = insert, d.tables[m.table][m.index]
+	}
+	return false
+}
+
+func (d *cuckooHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	table, index := d.find(hashIndex, key)
+	if table == -1 {
+		return *new(V), false
+	}
+	value := d.slot(table, index).value.v
+	d.remove(table, index)
+	return value, true
+}
+
+func (d *cuckooHashMapData[K, V]) remove(table, index int) {
+	if table == 2 {
+		last := len(d.stash) - 1
+		d.stash[index], d.stash[last] = d.stash[last], cuckooSlot[K, V]{}
+		d.stash = d.stash[:last]
+		return
+	}
+	d.tables[table][index] = cuckooSlot[K, V]{}
+}
+
+func (d *cuckooHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, slots := range [][]cuckooSlot[K, V]{d.tables[0], d.tables[1], d.stash} {
+		for _, slot := range slots {
+			if slot.value == nil {
+				continue
+			}
+			if !op(slot.value) {
+				return
+			}
+		}
+	}
+}
+
+// Reallocate always rebuilds with a new secondary seed, so a cycling table gets a fresh chance
+func (d *cuckooHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := d.allocate(size, mix64(d.seed+1))
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.tables, d.stash, d.seed = newData.tables, newData.stash, newData.seed
+	return true
+}
+
+func (d *cuckooHashMapData[K, V]) allocate(size uint, seed uint64) *cuckooHashMapData[K, V] {
+	return &cuckooHashMapData[K, V]{
+		tables: [2][]cuckooSlot[K, V]{
+			make([]cuckooSlot[K, V], size),
+			make([]cuckooSlot[K, V], size),
+		},
+		seed: seed,
+	}
+}
+
+func (d *cuckooHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size, d.seed)
+}
+
+// Evacuate takes both tables at hash index, and the stash along with the last index
+func (d *cuckooHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	for table := 0; table != 2; table++ {
+		if slot := d.tables[table][hashIndex]; slot.value != nil {
+			d.remove(table, hashIndex)
+			op(slot.value)
+		}
+	}
+	if hashIndex == d.Len()-1 {
+		for len(d.stash) != 0 {
+			slot := d.stash[0]
+			d.remove(2, 0)
+			op(slot.value)
+		}
+	}
+}
+
+func (d *cuckooHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	iterators := make([]HashMapDataIterator[K, V], 0, 3)
+	for _, slots := range [][]cuckooSlot[K, V]{d.tables[0], d.tables[1], d.stash} {
+		slots := slots
+		iterators = append(iterators, &sliceIterator[K, V]{
+			length: len(slots),
+			value: func(index int) *HashValue[K, V] {
+				return slots[index].value
+			},
+		})
+	}
+	return &chainIterator[K, V]{
+		iterators: iterators,
+	}
+}
+
+// hopscotch hashing
+
+// every key stays within HOPSCOTCH_NEIGHBORHOOD slots of its home, the home slot keeps a hop-info bitmap of them
+
+const HOPSCOTCH_NEIGHBORHOOD = 32
+
+type hopscotchSlot[K comparable, V any] struct {
+	value   *HashValue[K, V]
+	hopInfo uint32 // bit i set: slot home+i holds a key whose home is this slot
+}
+
+type hopscotchHashMapData[K comparable, V any] struct {
+	slots []hopscotchSlot[K, V]
+}
+
+func (d *hopscotchHashMapData[K, V]) Len() int {
+	return len(d.slots)
+}
+
+func (d *hopscotchHashMapData[K, V]) neighborhood() int {
+	if len(d.slots) < HOPSCOTCH_NEIGHBORHOOD {
+		return len(d.slots)
+	}
+	return HOPSCOTCH_NEIGHBORHOOD
+}
+
+func (d *hopscotchHashMapData[K, V]) at(hashIndex, distance int) int {
+	return (hashIndex + distance) % len(d.slots)
+}
+
+// find returns the distance of key from its home, -1 if not exists
+func (d *hopscotchHashMapData[K, V]) find(hashIndex int, key K) int {
+	for hopInfo, distance := d.slots[hashIndex].hopInfo, 0; hopInfo != 0; hopInfo, distance = hopInfo>>1, distance+1 {
+		if hopInfo&1 != 0 && d.slots[d.at(hashIndex, distance)].value.k == key {
+			return distance
+		}
+	}
+	return -1
+}
+
+func (d *hopscotchHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if distance := d.find(hashIndex, key); distance != -1 {
+		return d.slots[d.at(hashIndex, distance)].value.v, true
+	}
+	return *new(V), false
+}
+
+func (d *hopscotchHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	if distance := d.find(hashIndex, hashValue.k); distance != -1 {
+		d.slots[d.at(hashIndex, distance)].value = hashValue
+		return true
+	}
+
+	// linear probe for the nearest empty slot
+	distance := 0
+	for distance != len(d.slots) && d.slots[d.at(hashIndex, distance)].value != nil {
+		distance++
+	}
+	if distance == len(d.slots) {
+		return false
+	}
+
+	// hop the empty slot back towards home by moving a closer key into it
+	neighborhood := d.neighborhood()
+	for distance >= neighborhood {
+		empty := d.at(hashIndex, distance)
+		moved := false
+		for back := neighborhood - 1; back > 0 && !moved; back-- {
+			home := d.at(empty, len(d.slots)-back)
+			for bit := 0; bit < back; bit++ {
+				if d.slots[home].hopInfo&(1<<bit) == 0 {
+					continue
+				}
+				from := d.at(home, bit)
+				d.slots[empty].value, d.slots[from].value = d.slots[from].value, nil
+				d.slots[home].hopInfo ^= 1<<bit | 1<<back
+				distance -= back - bit
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			// neighborhood is full, HashMap has to grow
+			return false
+		}
+	}
+	d.slots[d.at(hashIndex, distance)].value = hashValue
+	d.slots[hashIndex].hopInfo |= 1 << distance
+	return true
+}
+
+func (d *hopscotchHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	distance := d.find(hashIndex, key)
+	if distance == -1 {
+		return *new(V), false
+	}
+	index := d.at(hashIndex, distance)
+	value := d.slots[index].value.v
+	d.slots[index].value = nil
+	d.slots[hashIndex].hopInfo &^= 1 << distance
+	return value, true
+}
+
+func (d *hopscotchHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, slot := range d.slots {
+		if slot.value == nil {
+			continue
+		}
+		if !op(slot.value) {
+			return
+		}
+	}
+}
+
+func (d *hopscotchHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.slots)) == size {
+		return true
+	}
+	newData := &hopscotchHashMapData[K, V]{
+		slots: make([]hopscotchSlot[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.slots = newData.slots
+	return true
+}
+
+func (d *hopscotchHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &hopscotchHashMapData[K, V]{
+		slots: make([]hopscotchSlot[K, V], size),
+	}
+}
+
+// Evacuate takes every key whose home is hash index
+func (d *hopscotchHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	for hopInfo, distance := d.slots[hashIndex].hopInfo, 0; hopInfo != 0; hopInfo, distance = hopInfo>>1, distance+1 {
+		if hopInfo&1 == 0 {
+			continue
+		}
+		index := d.at(hashIndex, distance)
+		hashValue := d.slots[index].value
+		d.slots[index].value = nil
+		op(hashValue)
+	}
+	d.slots[hashIndex].hopInfo = 0
+}
+
+func (d *hopscotchHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	slots := d.slots
+	return &sliceIterator[K, V]{
+		length: len(slots),
+		value: func(index int) *HashValue[K, V] {
+			return slots[index].value
+		},
+	}
+}
+
+// swiss table
+
+// one control byte per slot, a group of SWISS_GROUP_SIZE control bytes is scanned at once as an uint64
+
+const (
+	SWISS_GROUP_SIZE = 8
+	swissEmpty       = 0x00 // zero value, so a fresh control slice is all empty
+	swissDeleted     = 0x01
+	swissFull        = 0x80 // full slot is swissFull | 7 bits H2 hash fragment
+
+	swissLSB = 0x0101010101010101
+	swissMSB = 0x8080808080808080
+)
+
+// swissHashMapData keeps values in a flat slice instead of []*HashValue,
+// its length must be a multiple of SWISS_GROUP_SIZE
+type swissHashMapData[K comparable, V any] struct {
+	ctrl       []byte
+	entries    []HashValue[K, V]
+	count      int
+	tombstones int
+}
+
+func (d *swissHashMapData[K, V]) Len() int {
+	return len(d.ctrl)
+}
+
+func (d *swissHashMapData[K, V]) Tombstones() int {
+	return d.tombstones
+}
+
+// swissH2 takes 7 bits of the key's own hash, independent of the hash index from HashMap
+func swissH2[K comparable](key K) byte {
+	return swissFull | byte(mix64(hash64(key))>>57)
+}
+
+func (d *swissHashMapData[K, V]) group(g int) uint64 {
+	return binary.LittleEndian.Uint64(d.ctrl[g*SWISS_GROUP_SIZE:])
+}
+
+// swissMatch sets the high bit of every byte equal to b, a byte right above a real match may be a false positive
+func swissMatch(group uint64, b byte) uint64 {
+	x := group ^ (swissLSB * uint64(b))
+	return (x - swissLSB) &^ x & swissMSB
+}
+
+// swissMatchEmpty has the same false positive as swissMatch, but the lowest bit is always right
+func swissMatchEmpty(group uint64) uint64 {
+	return swissMatch(group, swissEmpty)
+}
+
+func swissMatchEmptyOrDeleted(group uint64) uint64 {
+	return ^group & swissMSB
+}
+
+// probe visits groups by triangular numbers, every group once when the group count is a power of two
+func (d *swissHashMapData[K, V]) probe(hashIndex int, op func(g int, group uint64) bool) {
+	groups := len(d.ctrl) / SWISS_GROUP_SIZE
+	g := (hashIndex / SWISS_GROUP_SIZE) % groups
+	for step := 1; step <= groups; step++ {
+		if !op(g, d.group(g)) {
+			return
+		}
+		g = (g + step) % groups
+	}
+}
+
+// find returns the slot of key, the first free slot on its probe sequence, or -1
+func (d *swissHashMapData[K, V]) find(hashIndex int, key K) (int, int) {
+	h2 := swissH2(key)
+	index, free := -1, -1
+	d.probe(hashIndex, func(g int, group uint64) bool {
+		for match := swissMatch(group, h2); match != 0; match &= match - 1 {
+			slot := g*SWISS_GROUP_SIZE + bits.TrailingZeros64(match)/8
+			if d.ctrl[slot] == h2 && d.entries[slot].k == key {
+				index = slot
+				return false
+			}
+		}
+		if match := swissMatchEmptyOrDeleted(group); free == -1 && match != 0 {
+			free = g*SWISS_GROUP_SIZE + bits.TrailingZeros64(match)/8
+		}
+		return swissMatchEmpty(group) == 0
+	})
+	return index, free
+}
+
+func (d *swissHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if index, _ := d.find(hashIndex, key); index != -1 {
+		return d.entries[index].v, true
+	}
+	return *new(V), false
+}
+
+func (d *swissHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	index, free := d.find(hashIndex, hashValue.k)
+	if index != -1 {
+		d.entries[index].v = hashValue.v
+		return true
+	}
+	if free == -1 {
+		return false
+	}
+	if d.ctrl[free] == swissDeleted {
+		d.tombstones--
+	}
+	d.ctrl[free] = swissH2(hashValue.k)
+	d.entries[free] = *hashValue
+	d.count++
+	return true
+}
+
+func (d *swissHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	index, _ := d.find(hashIndex, key)
+	if index == -1 {
+		return *new(V), false
+	}
+	value := d.entries[index].v
+	d.erase(index)
+	return value, true
+}
+
+// erase leaves a tombstone, unless the group still has an empty slot so no probe ever passed it
+func (d *swissHashMapData[K, V]) erase(index int) {
+	if swissMatchEmpty(d.group(index/SWISS_GROUP_SIZE)) != 0 {
+		d.ctrl[index] = swissEmpty
+	} else {
+		d.ctrl[index] = swissDeleted
+		d.tombstones++
+	}
+	d.entries[index] = HashValue[K, V]{}
+	d.count--
+}
+
+func (d *swissHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for index, ctrl := range d.ctrl {
+		if ctrl&swissFull == 0 {
+			continue
+		}
+		if !op(&d.entries[index]) {
+			return
+		}
+	}
+}
+
+// Reallocate rebuilds even at the same size which clears every tombstone, size rounds up to whole groups
+func (d *swissHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := d.allocate(size)
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.ctrl, d.entries, d.count, d.tombstones = newData.ctrl, newData.entries, newData.count, 0
+	return true
+}
+
+func (d *swissHashMapData[K, V]) allocate(size uint) *swissHashMapData[K, V] {
+	size = (size + SWISS_GROUP_SIZE - 1) / SWISS_GROUP_SIZE * SWISS_GROUP_SIZE
+	if size == 0 {
+		size = SWISS_GROUP_SIZE
+	}
+	return &swissHashMapData[K, V]{
+		ctrl:    make([]byte, size),
+		entries: make([]HashValue[K, V], size),
+	}
+}
+
+func (d *swissHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
+
+// Evacuate hands over a copy, the entry is cleared from the flat slice
+func (d *swissHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	if d.ctrl[hashIndex]&swissFull == 0 {
+		return
+	}
+	hashValue := d.entries[hashIndex]
+	d.erase(hashIndex)
+	op(&hashValue)
+}
+
+func (d *swissHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	ctrl, entries := d.ctrl, d.entries
+	return &sliceIterator[K, V]{
+		length: len(ctrl),
+		value: func(index int) *HashValue[K, V] {
+			if ctrl[index]&swissFull == 0 {
+				return nil
+			}
+			return &entries[index]
+		},
+	}
+}
+
+// double hashing - DH
+
+// the probe step comes from a second hash of the key, keys sharing a hash index do not share a probe sequence
+
+type doubleHashMapData[K comparable, V any] struct {
+	array      []*HashValue[K, V]
+	tombstone  *HashValue[K, V] // marks deleted slot, probing goes on over it but stops at nil
+	tombstones int
+}
+
+func (d *doubleHashMapData[K, V]) Len() int {
+	return len(d.array)
+}
+
+func (d *doubleHashMapData[K, V]) Tombstones() int {
+	return d.tombstones
+}
+
+func (d *doubleHashMapData[K, V]) isTombstone(index int) bool {
+	return d.array[index] != nil && d.array[index] == d.tombstone
+}
+
+// stride is the second hash of key, coprime to len(d.array) so len(d.array) steps visit every slot
+func (d *doubleHashMapData[K, V]) stride(key K) int {
+	size := uint64(len(d.array))
+	if size <= 1 {
+		return 1
+	}
+	h := mix64(hash64(key) ^ 0x9e3779b97f4a7c15)
+	if size&(size-1) == 0 {
+		// every odd number is coprime to a power of two
+		return int((h | 1) & (size - 1))
+	}
+	stride := 1 + h%(size-1)
+	for gcd(stride, size) != 1 {
+		stride = stride%(size-1) + 1
+	}
+	return int(stride)
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func (d *doubleHashMapData[K, V]) get(hashIndex int, key K, op func(int) (V, bool)) (V, bool) {
+	stride := d.stride(key)
+	for step, index := 0, hashIndex; step != len(d.array); step, index = step+1, (index+stride)%len(d.array) {
+		if d.array[index] == nil {
+			break
+		}
+		if !d.isTombstone(index) && d.array[index].k == key {
+			return op(index)
+		}
+	}
+	return *new(V), false
+}
+
+func (d *doubleHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	reuseIndex := -1
+	stride := d.stride(hashValue.k)
+	for step, index := 0, hashIndex; step != len(d.array); step, index = step+1, (index+stride)%len(d.array) {
+		if d.array[index] == nil {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+			break
+		}
+		if d.isTombstone(index) {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+		} else if d.array[index].k == hashValue.k {
+			d.array[index] = hashValue
+			return true
+		}
+	}
+	if reuseIndex == -1 {
+		return false
+	}
+	if d.isTombstone(reuseIndex) {
+		d.tombstones--
+	}
+	d.array[reuseIndex] = hashValue
+	return true
+}
+
+func (d *doubleHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
+		return d.array[index].v, true
+	})
+}
+
+func (d *doubleHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
+		value := d.array[index].v
+		d.bury(index)
+		return value, true
+	})
+}
+
+// bury leaves a tombstone at index, the probe sequences passing through it are unknown
+func (d *doubleHashMapData[K, V]) bury(index int) {
+	if d.tombstone == nil {
+		d.tombstone = &HashValue[K, V]{}
+	}
+	d.array[index] = d.tombstone
+	d.tombstones++
+}
+
+func (d *doubleHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for index, hashValue := range d.array {
+		if hashValue == nil || d.isTombstone(index) {
+			continue
+		}
+		if !op(hashValue) {
+			return
+		}
+	}
+}
+
+// Reallocate rebuilds the array even at the same size, which clears every tombstone
+func (d *doubleHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := &doubleHashMapData[K, V]{
+		array: make([]*HashValue[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.array, d.tombstones = newData.array, 0
+	return true
+}
+
+func (d *doubleHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &doubleHashMapData[K, V]{
+		array: make([]*HashValue[K, V], size),
+	}
+}
+
+func (d *doubleHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	if hashValue := d.array[hashIndex]; hashValue != nil && !d.isTombstone(hashIndex) {
+		d.bury(hashIndex)
+		op(hashValue)
+	}
+}
+
+func (d *doubleHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	array, tombstone := d.array, d.tombstone
+	return &sliceIterator[K, V]{
+		length: len(array),
+		value: func(index int) *HashValue[K, V] {
+			if array[index] == tombstone {
+				return nil
+			}
+			return array[index]
+		},
+	}
+}
+
+// random detection and hashing - RDH
+
+// every key walks its own pseudo-random permutation of offsets, generated by a full period LCG seeded by the key
+
+type randomProbeHashMapData[K comparable, V any] struct {
+	array      []*HashValue[K, V]
+	tombstone  *HashValue[K, V] // marks deleted slot, probing goes on over it but stops at nil
+	tombstones int
+	seed       uint64 // mixed into every key, probe sequences are reproducible with the same seed
+}
+
+// randomProbe is the probe state of one key
+type randomProbe struct {
+	multiplier uint64 // multiplier%4 == 1
+	increment  uint64 // odd
+	mask       uint64 // period of LCG, the smallest power of two not less than size
+	size       uint64
+	offset     uint64
+}
+
+// next returns the next offset, offsets out of size are skipped so the first size offsets are a permutation of [0, size)
+func (p *randomProbe) next() int {
+	offset := p.offset
+	for {
+		p.offset = (p.multiplier*p.offset + p.increment) & p.mask
+		if offset < p.size {
+			return int(offset)
+		}
+		offset = p.offset
+	}
+}
+
+func (d *randomProbeHashMapData[K, V]) Len() int {
+	return len(d.array)
+}
+
+func (d *randomProbeHashMapData[K, V]) Tombstones() int {
+	return d.tombstones
+}
+
+func (d *randomProbeHashMapData[K, V]) isTombstone(index int) bool {
+	return d.array[index] != nil && d.array[index] == d.tombstone
+}
+
+// probe starts with offset 0, so the hash index itself is always tried first
+func (d *randomProbeHashMapData[K, V]) probe(key K) *randomProbe {
+	h := mix64(hash64(key) ^ d.seed)
+	size := uint64(len(d.array))
+	return &randomProbe{
+		multiplier: h<<2 | 1,
+		increment:  h>>32 | 1,
+		mask:       1<<bits.Len64(size-1) - 1,
+		size:       size,
+	}
+}
+
+func (d *randomProbeHashMapData[K, V]) get(hashIndex int, key K, op func(int) (V, bool)) (V, bool) {
+	probe := d.probe(key)
+	for step := 0; step != len(d.array); step++ {
+		index := (hashIndex + probe.next()) % len(d.array)
+		if d.array[index] == nil {
+			break
+		}
+		if !d.isTombstone(index) && d.array[index].k == key {
+			return op(index)
+		}
+	}
+	return *new(V), false
+}
+
+func (d *randomProbeHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	reuseIndex := -1
+	probe := d.probe(hashValue.k)
+	for step := 0; step != len(d.array); step++ {
+		index := (hashIndex + probe.next()) % len(d.array)
+		if d.array[index] == nil {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+			break
+		}
+		if d.isTombstone(index) {
+			if reuseIndex == -1 {
+				reuseIndex = index
+			}
+		} else if d.array[index].k == hashValue.k {
+			d.array[index] = hashValue
+			return true
+		}
+	}
+	if reuseIndex == -1 {
+		return false
+	}
+	if d.isTombstone(reuseIndex) {
+		d.tombstones--
+	}
+	d.array[reuseIndex] = hashValue
+	return true
+}
+
+func (d *randomProbeHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
+		return d.array[index].v, true
+	})
+}
+
+func (d *randomProbeHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	return d.get(hashIndex, key, func(index int) (V, bool) {
+		value := d.array[index].v
+		d.bury(index)
+		return value, true
+	})
+}
+
+// bury leaves a tombstone at index, the probe sequences passing through it are unknown
+func (d *randomProbeHashMapData[K, V]) bury(index int) {
+	if d.tombstone == nil {
+		d.tombstone = &HashValue[K, V]{}
+	}
+	d.array[index] = d.tombstone
+	d.tombstones++
+}
+
+func (d *randomProbeHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for index, hashValue := range d.array {
+		if hashValue == nil || d.isTombstone(index) {
+			continue
+		}
+		if !op(hashValue) {
+			return
+		}
+	}
+}
+
+// Reallocate rebuilds the array even at the same size, which clears every tombstone
+func (d *randomProbeHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := &randomProbeHashMapData[K, V]{
+		array: make([]*HashValue[K, V], size),
+		seed:  d.seed,
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.array, d.tombstones = newData.array, 0
+	return true
+}
+
+func (d *randomProbeHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &randomProbeHashMapData[K, V]{
+		array: make([]*HashValue[K, V], size),
+		seed:  d.seed,
+	}
+}
+
+func (d *randomProbeHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	if hashValue := d.array[hashIndex]; hashValue != nil && !d.isTombstone(hashIndex) {
+		d.bury(hashIndex)
+		op(hashValue)
+	}
+}
+
+func (d *randomProbeHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	array, tombstone := d.array, d.tombstone
+	return &sliceIterator[K, V]{
+		length: len(array),
+		value: func(index int) *HashValue[K, V] {
+			if array[index] == tombstone {
+				return nil
+			}
+			return array[index]
+		},
+	}
+}
+
+// chain address collision
+
+// doubly linked list - DLL
+
+type dllNode[K comparable, V any] struct {
+	nextNode *dllNode[K, V]
+	preNode  *dllNode[K, V]
+	value    *HashValue[K, V]
+}
+
+type dllHashMapData[K comparable, V any] struct {
+	buckets []*dllNode[K, V]
+	cow     copyOnWrite
+}
+
+func (d *dllHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *dllHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	for p := d.buckets[hashIndex]; p != nil; p = p.nextNode {
+		if p.value != nil && p.value.k == key {
+			return p.value.v, true
+		}
+	}
+	return *new(V), false
+}
+
+func (d *dllHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	var preNode *dllNode[K, V]
+	for p := d.buckets[hashIndex]; p != nil; p = p.nextNode {
+		if p.value.k == hashValue.k {
+			p.value = hashValue
+			return true
+		} else {
+			preNode = p
+		}
+	}
+	vNode := &dllNode[K, V]{
+		value: hashValue,
+	}
+	if preNode == nil {
+		d.buckets[hashIndex] = vNode
+	} else {
+		preNode.nextNode = vNode
+		vNode.preNode = preNode
+	}
+	return true
+}
+
+func (d *dllHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	for p := d.buckets[hashIndex]; p != nil; p = p.nextNode {
+		if p.value != nil && p.value.k == key {
+			value := p.value.v
+			p.value = nil
+			if p.preNode == nil { // bucket head
+				if p.nextNode == nil {
+					d.buckets[hashIndex] = nil
+				} else {
+					d.buckets[hashIndex] = p.nextNode
+					d.buckets[hashIndex].preNode = nil
+					p.preNode = nil
+					p.nextNode = nil
+				}
+			} else if p.nextNode == nil { // bucket tail
+				p.preNode.nextNode = nil
+				p.preNode = nil
+				p.nextNode = nil
+			} else { // bucket middle
+				p.preNode.nextNode = p.nextNode
+				p.nextNode.preNode = p.preNode
+				p.preNode = nil
+				p.nextNode = nil
+			}
+			return value, true
+		}
+	}
+	return *new(V), false
+}
+
+func (d *dllHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		for node := bucket; node != nil; node = node.nextNode {
+			if !op(node.value) {
+				return
+			}
+		}
+	}
+}
+
+func (d *dllHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := &dllHashMapData[K, V]{
+		buckets: make([]*dllNode[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets, d.cow = newData.buckets, copyOnWrite{}
+	return true
+}
+
+func (d *dllHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &dllHashMapData[K, V]{
+		buckets: make([]*dllNode[K, V], size),
+	}
+}
+
+func (d *dllHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.own(hashIndex)
+	bucket := d.buckets[hashIndex]
+	d.buckets[hashIndex] = nil
+	for node := bucket; node != nil; node = node.nextNode {
+		op(node.value)
+	}
+}
+
+// Iterator stops early in a bucket if the node it stands on is deleted, since Del unlinks the node
+func (d *dllHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	buckets := d.buckets
+	var node *dllNode[K, V]
+	return &bucketIterator[K, V]{
+		length: len(buckets),
+		next: func(index int) *HashValue[K, V] {
+			if node == nil {
+				node = buckets[index]
+			} else {
+				node = node.nextNode
+			}
+			for node != nil && node.value == nil {
+				node = node.nextNode
+			}
+			if node == nil {
+				return nil
+			}
+			return node.value
+		},
+	}
+}
+
+// clone copies the whole chain, the values are shared since Set replaces a value instead of changing it
+func (n *dllNode[K, V]) clone() *dllNode[K, V] {
+	var head, tail *dllNode[K, V]
+	for p := n; p != nil; p = p.nextNode {
+		node := &dllNode[K, V]{
+			preNode: tail,
+			value:   p.value,
+		}
+		if tail == nil {
+			head = node
+		} else {
+			tail.nextNode = node
+		}
+		tail = node
+	}
+	return head
+}
+
+func (d *dllHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, (*dllNode[K, V]).clone)
+}
+
+func (d *dllHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &dllHashMapData[K, V]{
+		buckets: d.buckets,
+	}
+}
+
+// binary search tree - BST
+
+type bstNode[K Ordered, V any] struct {
+	leftChild  *bstNode[K, V]
+	rightChild *bstNode[K, V]
+	value      *HashValue[K, V]
+}
+
+func (n *bstNode[K, V]) preOrderTraversal(op func(*HashValue[K, V]) bool, deep int) bool {
+	fmt.Printf("%v", strings.Repeat("\t", deep))
+	if !op(n.value) {
+		return false
+	}
+	if n.leftChild != nil {
+		n.leftChild.preOrderTraversal(op, deep+1)
+	}
+	if n.rightChild != nil {
+		n.rightChild.preOrderTraversal(op, deep+1)
+	}
+	return true
+}
+
+func (n *bstNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n.leftChild != nil && !n.leftChild.inOrderTraversal(op) {
+		return false
+	}
+	if !op(n.value) {
+		return false
+	}
+	if n.rightChild != nil && !n.rightChild.inOrderTraversal(op) {
+		return false
+	}
+	return true
+}
+
+type bstHashMapData[K Ordered, V any] struct {
+	buckets []*bstNode[K, V]
+	cow     copyOnWrite
+}
+
+func (d *bstHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *bstHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if d.buckets[hashIndex] == nil {
+		return *new(V), false
+	} else {
+		node := d.buckets[hashIndex]
+		for {
+			if key < node.value.k {
+				if node.leftChild == nil {
+					return *new(V), false
+				} else {
+					node = node.leftChild
+				}
+			} else if node.value.k < key {
+				if node.rightChild == nil {
+					return *new(V), false
+				} else {
+					node = node.rightChild
+				}
+			} else {
+				return node.value.v, true
+			}
+		}
+	}
+}
+
+func (d *bstHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	vNode := &bstNode[K, V]{
+		value: hashValue,
+	}
+	if d.buckets[hashIndex] == nil {
+		d.buckets[hashIndex] = vNode
+	} else {
+		node := d.buckets[hashIndex]
+		for {
+			if hashValue.k < node.value.k {
+				if node.leftChild == nil {
+					node.leftChild = vNode
+					return true
+				} else {
+					node = node.leftChild
+				}
+			} else if node.value.k < hashValue.k {
+				if node.rightChild == nil {
+					node.rightChild = vNode
+					return true
+				} else {
+					node = node.rightChild
+				}
+			} else {
+				node.value = hashValue
+				return true
+			}
+		}
+	}
+	return true
+}
+
+// 移动左子树到右子树最小节点的左子树下（树易失衡）
+func (d *bstHashMapData[K, V]) del(hashIndex int, key K) (V, bool) {
+	if d.buckets[hashIndex] == nil {
+		return *new(V), false
+	} else {
+		var parentNode *bstNode[K, V]
+		node := d.buckets[hashIndex]
+		for {
+			if key < node.value.k {
+				if node.leftChild == nil {
+					return *new(V), false
+				} else {
+					parentNode = node
+					node = node.leftChild
+				}
+			} else if node.value.k < key {
+				if node.rightChild == nil {
+					return *new(V), false
+				} else {
+					parentNode = node
+					node = node.rightChild
+				}
+			} else {
+				value := node.value.v
+				if parentNode == nil {
+					d.buckets[hashIndex] = nil
+				} else {
+					if parentNode.leftChild == node {
+						if node.rightChild == nil {
+							parentNode.leftChild = node.leftChild
+						} else {
+							parentNode.leftChild = node.rightChild
+							if node.leftChild != nil {
+								leftSubNode := node.leftChild
+								for node = node.rightChild; node.leftChild != nil; node = node.leftChild {
+								}
+								node.leftChild = leftSubNode
+							}
+						}
+					} else if parentNode.rightChild == node {
+						if node.rightChild == nil {
+							parentNode.rightChild = node.leftChild
+						} else {
+							parentNode.rightChild = node.rightChild
+							if node.leftChild != nil {
+								leftSubNode := node.leftChild
+								for node = node.rightChild; node.leftChild != nil; node = node.leftChild {
+								}
+								node.leftChild = leftSubNode
+							}
+						}
+					} else {
+						// TODO: BST error, need range and print tree
+						return *new(V), false
+					}
+				}
+				return value, true
+			}
+		}
+	}
+}
+
+// 删除匹配节点，移动右子树最小节点到匹配节点
+func (d *bstHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	if d.buckets[hashIndex] == nil {
+		return *new(V), false
+	} else {
+		// fmt.Println()
+		// fmt.Printf("Before Delete %v preOrder\n", key)
+		// d.buckets[hashIndex].preOrderTraversal(func(h *HashValue[K, V]) bool {
+		// 	fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
+		// 	return true
+		// }, 0)
+		var parentNode *bstNode[K, V]
+		node := d.buckets[hashIndex]
+		for {
+			if key < node.value.k {
+				if node.leftChild == nil {
+					return *new(V), false
+				} else {
+					parentNode = node
+					node = node.leftChild
+				}
+			} else if node.value.k < key {
+				if node.rightChild == nil {
+					return *new(V), false
+				} else {
+					parentNode = node
+					node = node.rightChild
+				}
+			} else {
+				value, deleteNode := node.value.v, node
+				var newNode *bstNode[K, V]
+				leftChild := node.leftChild
+				rightChild := node.rightChild
+				minRightNodeParentNode := node
+				for node = node.rightChild; node != nil && node.leftChild != nil; minRightNodeParentNode, node = node, node.leftChild {
+				}
+				if node == nil { // 单左链表
+					newNode = leftChild
+				} else if minRightNodeParentNode == deleteNode { // 单右链表
+					newNode = deleteNode.rightChild
+					newNode.leftChild = leftChild
+				} else if minRightNodeParentNode != node {
+					minRightNodeParentNode.leftChild = node.rightChild
+					node.leftChild = leftChild
+					node.rightChild = rightChild
+					newNode = node
+				} else {
+					newNode = node.rightChild
+				}
+
+				if parentNode == nil {
+					d.buckets[hashIndex] = newNode
+				} else if parentNode.leftChild == deleteNode {
+					parentNode.leftChild = newNode
+				} else if parentNode.rightChild == deleteNode {
+					parentNode.rightChild = newNode
+				} else {
+					// TODO: error
+					return *new(V), false
+				}
+
+				deleteNode.leftChild = nil
+				deleteNode.rightChild = nil
+
+				// if d.buckets[hashIndex] != nil {
+				// 	fmt.Printf("After Delete %v preOrder\n", key)
+				// 	d.buckets[hashIndex].preOrderTraversal(func(h *HashValue[K, V]) bool {
+				// 		fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
+				// 		return true
+				// 	}, 0)
+				// }
+				return value, true
+			}
+		}
+		// fmt.Printf("DEBUG: search but not find!")
+		// d.buckets[hashIndex].inOrderTraversal(func(h *HashValue[K, V]) bool {
+		// 	fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
+		// 	return true
+		// })
+	}
+}
+
+func (d *bstHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		if bucket != nil && !bucket.inOrderTraversal(op) {
+			return
+		}
+	}
+}
+
+func (d *bstHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := &bstHashMapData[K, V]{
+		buckets: make([]*bstNode[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets, d.cow = newData.buckets, copyOnWrite{}
+	return true
+}
+
+func (d *bstHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &bstHashMapData[K, V]{
+		buckets: make([]*bstNode[K, V], size),
+	}
+}
+
+func (d *bstHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.own(hashIndex)
+	bucket := d.buckets[hashIndex]
+	if bucket == nil {
+		return
+	}
+	d.buckets[hashIndex] = nil
+	bucket.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		op(hashValue)
+		return true
+	})
+}
+
+func (d *bstHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &treeIterator[K, V, *bstNode[K, V]]{
+		buckets: d.buckets,
+		child: func(node *bstNode[K, V], position int) *bstNode[K, V] {
+			if position == 0 {
+				return node.leftChild
+			}
+			return node.rightChild
+		},
+		value: func(node *bstNode[K, V], position int) *HashValue[K, V] {
+			if position == 0 {
+				return node.value
+			}
+			return nil
+		},
+	}
+}
+
+func (n *bstNode[K, V]) clone() *bstNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	return &bstNode[K, V]{
+		leftChild:  n.leftChild.clone(),
+		rightChild: n.rightChild.clone(),
+		value:      n.value,
+	}
+}
+
+func (d *bstHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, (*bstNode[K, V]).clone)
+}
+
+func (d *bstHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &bstHashMapData[K, V]{
+		buckets: d.buckets,
+	}
+}
+
+// avl tree - AVLT
+
+type avltNode[K Ordered, V any] struct {
+	parentNode  *avltNode[K, V]
+	leftHeight  int
+	leftChild   *avltNode[K, V]
+	rightHeight int
+	rightChild  *avltNode[K, V]
+	value       *HashValue[K, V]
+}
+
+func (n *avltNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n.leftChild != nil && !n.leftChild.inOrderTraversal(op) {
+		return false
+	}
+	if !op(n.value) {
+		return false
+	}
+	if n.rightChild != nil && !n.rightChild.inOrderTraversal(op) {
+		return false
+	}
+	return true
+}
+
+// checkInvariant 检查键有序、父节点指针、记录的左右高度以及平衡因子，返回高度
+func (n *avltNode[K, V]) checkInvariant() int {
+	if n == nil {
+		return 0
+	}
+	for _, child := range []*avltNode[K, V]{n.leftChild, n.rightChild} {
+		if child != nil && child.parentNode != n {
+			panic(fmt.Sprintf("node %v child %v parent is wrong\n", n.value.k, child.value.k))
+		}
+	}
+	if n.leftChild != nil && !(n.leftChild.value.k < n.value.k) || n.rightChild != nil && !(n.value.k < n.rightChild.value.k) {
+		panic(fmt.Sprintf("node %v children out of order\n", n.value.k))
+	}
+	leftHeight, rightHeight := n.leftChild.checkInvariant(), n.rightChild.checkInvariant()
+	if leftHeight != n.leftHeight || rightHeight != n.rightHeight {
+		panic(fmt.Sprintf("node %v heights %v, %v not equal to recorded %v, %v\n", n.value.k, leftHeight, rightHeight, n.leftHeight, n.rightHeight))
+	}
+	if diff := leftHeight - rightHeight; diff < -1 || 1 < diff {
+		panic(fmt.Sprintf("node %v lost balance, left height %v right height %v\n", n.value.k, leftHeight, rightHeight))
+	}
+	return n.getHeight() + 1
+}
+
+type rotateType int
+
+const (
+	UNKNOWN rotateType = iota
+	LL
+	LR
+	RL
+	RR
+)
+
+func (n *avltNode[K, V]) getRotateType() rotateType {
+	factor := n.getBalanceFactor()
+	if n.leftChild != nil {
+		if factor > 1 && n.leftChild.getBalanceFactor() >= 0 {
+			return LL
+		}
+		if factor > 1 && n.leftChild.getBalanceFactor() < 0 {
+			return LR
+		}
+	}
+	if n.rightChild != nil {
+		if factor < -1 && n.rightChild.getBalanceFactor() <= 0 {
+			return RR
+		}
+		if factor < -1 && n.rightChild.getBalanceFactor() > 0 {
+			return RL
+		}
+	}
+	return UNKNOWN
+}
+
+func (n *avltNode[K, V]) setLeftChild(childNode *avltNode[K, V]) {
+	n.leftChild = childNode
+	if childNode != nil {
+		childNode.parentNode = n
+		n.leftHeight = childNode.getHeight() + 1
+	} else {
+		n.leftHeight = 0
+	}
+}
+
+func (n *avltNode[K, V]) setRightChild(childNode *avltNode[K, V]) {
+	n.rightChild = childNode
+	if childNode != nil {
+		childNode.parentNode = n
+		n.rightHeight = childNode.getHeight() + 1
+	} else {
+		n.rightHeight = 0
+	}
+}
+
+func (n *avltNode[K, V]) getHeight() int {
+	if n.leftHeight < n.rightHeight {
+		return n.rightHeight
+	}
+	return n.leftHeight
+}
+
+func (n *avltNode[K, V]) getBalanceFactor() int {
+	return n.leftHeight - n.rightHeight
+}
+
+func (n *avltNode[K, V]) leftRotate() *avltNode[K, V] {
+	newRootNode := n.rightChild
+	if newRootNode != nil {
+		n.setRightChild(newRootNode.leftChild)
+		newRootNode.setLeftChild(n)
+	} else {
+		n.setRightChild(nil)
+	}
+	return newRootNode
+}
+
+func (n *avltNode[K, V]) rightRotate() *avltNode[K, V] {
+	newRootNode := n.leftChild
+	if newRootNode != nil {
+		n.setLeftChild(newRootNode.rightChild)
+		newRootNode.setRightChild(n)
+	} else {
+		n.setLeftChild(nil)
+	}
+	return newRootNode
+}
+
+type avltHashMapData[K Ordered, V any] struct {
+	buckets []*avltNode[K, V]
+	cow     copyOnWrite
+}
+
+func (d *avltHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *avltHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if d.buckets[hashIndex] == nil {
+		return *new(V), false
+	} else {
+		node := d.buckets[hashIndex]
+		for {
+			if key < node.value.k {
+				if node.leftChild == nil {
+					return *new(V), false
+				} else {
+					node = node.leftChild
+				}
+			} else if node.value.k < key {
+				if node.rightChild == nil {
+					return *new(V), false
+				} else {
+					node = node.rightChild
+				}
+			} else {
+				return node.value.v, true
+			}
+		}
+	}
+}
+
+func (d *avltHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	vNode := &avltNode[K, V]{
+		value: hashValue,
+	}
+	if d.buckets[hashIndex] == nil {
+		d.buckets[hashIndex] = vNode
+	} else {
+		node := d.buckets[hashIndex]
+		for {
+			if hashValue.k < node.value.k {
+				if node.leftChild == nil {
+					vNode.parentNode = node
+					node.leftChild = vNode
+					break
+				} else {
+					node = node.leftChild
+				}
+			} else if node.value.k < hashValue.k {
+				if node.rightChild == nil {
+					vNode.parentNode = node
+					node.rightChild = vNode
+					break
+				} else {
+					node = node.rightChild
+				}
+			} else {
+				node.value = hashValue
+				return true
+			}
+		}
+	}
+
+	// balance
+	d.rebalanceUp(hashIndex, vNode.parentNode) // 自插入节点向上再平衡
+
+	return true
+}
+
+// type 1 delete leaf
+//   7              7
+//  5 8  -> Del(1) 5 8
+// 1 6 9            6 9
+// type 3 delete node only has right tree
+//   7               7
+//  5 8  -> Del(8)  5 9
+// 1 6 9           1 6
+// type 4 delete node has both left and right tree
+//   7               7
+//  5 8  -> Del(5)  6 8
+// 1 6 9           1   9
+func (d *avltHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	if d.buckets[hashIndex] == nil {
+		return *new(V), false
+	} else {
+		var parentNode *avltNode[K, V]
+		node := d.buckets[hashIndex]
+		for {
+			if key < node.value.k {
+				if node.leftChild == nil {
+					return *new(V), false
+				} else {
+					parentNode = node
+					node = node.leftChild
+				}
+			} else if node.value.k < key {
+				if node.rightChild == nil {
+					return *new(V), false
+				} else {
+					parentNode = node
+					node = node.rightChild
+				}
+			} else {
+				value, deleteNode := node.value.v, node
+				var newNode *avltNode[K, V]
+				leftChild := node.leftChild
+				rightChild := node.rightChild
+				minRightNodeParentNode := node
+				for node = node.rightChild; node != nil && node.leftChild != nil; minRightNodeParentNode, node = node, node.leftChild {
+				}
+				// 最深的变更节点
+				var checkNode *avltNode[K, V]
+				if node == nil { // 单左链表
+					newNode = leftChild
+					checkNode = parentNode
+				} else if minRightNodeParentNode == deleteNode { // 单右链表
+					newNode = deleteNode.rightChild
+					newNode.setLeftChild(leftChild)
+					checkNode = newNode
+				} else {
+					minRightNodeParentNode.setLeftChild(node.rightChild)
+					node.setLeftChild(leftChild)
+					node.setRightChild(rightChild)
+					newNode = node
+					checkNode = minRightNodeParentNode
+				}
+
+				if parentNode == nil {
+					d.buckets[hashIndex] = newNode
+					if newNode == nil {
+						return value, true
+					}
+					newNode.parentNode = nil
+				} else if parentNode.leftChild == deleteNode {
+					parentNode.setLeftChild(newNode)
+				} else if parentNode.rightChild == deleteNode {
+					parentNode.setRightChild(newNode)
+				} else {
+					panic(fmt.Sprintf("new node %v does has parent node %v but parent node not has new node\n", newNode.value.k, parentNode.value.k))
+				}
+
+				deleteNode.parentNode = nil
+				deleteNode.leftChild = nil
+				deleteNode.rightChild = nil
+
+				// balance
+				d.rebalanceUp(hashIndex, checkNode) // 自最深变更节点向上再平衡
+				return value, true
+			}
+		}
+	}
+}
+
+// rebalanceUp 自变更节点向上更新高度，失衡则旋转，直到根节点
+func (d *avltHashMapData[K, V]) rebalanceUp(hashIndex int, node *avltNode[K, V]) {
+	for node != nil {
+		node.setLeftChild(node.leftChild)
+		node.setRightChild(node.rightChild)
+		parentNode := node.parentNode
+		if diff := node.getBalanceFactor(); diff < -1 || 1 < diff {
+			lostBalanceNode := node
+			switch lostBalanceNode.getRotateType() {
+			case LR:
+				lostBalanceNode.setLeftChild(lostBalanceNode.leftChild.leftRotate())
+				fallthrough
+			case LL:
+				node = lostBalanceNode.rightRotate()
+			case RL:
+				lostBalanceNode.setRightChild(lostBalanceNode.rightChild.rightRotate())
+				fallthrough
+			case RR:
+				node = lostBalanceNode.leftRotate()
+			default:
+				panic(fmt.Sprintf("Error: lost balance node %v rotate type wrong\n", lostBalanceNode.value.k))
+			}
+			if parentNode == nil {
+				d.buckets[hashIndex] = node
+				node.parentNode = nil
+			} else if parentNode.leftChild == lostBalanceNode {
+				parentNode.setLeftChild(node)
+			} else {
+				parentNode.setRightChild(node)
+			}
+		}
+		node = parentNode
+	}
+}
+
+func (d *avltHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		if bucket != nil && !bucket.inOrderTraversal(op) {
+			return
+		}
+	}
+}
+
+// validateCheck 检查根没有父节点以及每个节点的AVL性质
+func (d *avltHashMapData[K, V]) validateCheck() {
+	for _, bucket := range d.buckets {
+		if bucket == nil {
+			continue
+		}
+		if bucket.parentNode != nil {
+			panic(fmt.Sprintf("root node %v has parent\n", bucket.value.k))
+		}
+		bucket.checkInvariant()
+	}
+}
+
+func (d *avltHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := &avltHashMapData[K, V]{
+		buckets: make([]*avltNode[K, V], size),
+	}
+	if !reallocate[K, V](func(op func(*HashValue[K, V]) bool) {
+		for _, bucket := range d.buckets {
+			if bucket != nil && !bucket.inOrderTraversal(op) {
+				return
+			}
+		}
+	}, newData, hasher) {
+		return false
+	}
+	d.buckets, d.cow = newData.buckets, copyOnWrite{}
+	return true
+}
+
+func (d *avltHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &avltHashMapData[K, V]{
+		buckets: make([]*avltNode[K, V], size),
+	}
+}
+
+func (d *avltHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.own(hashIndex)
+	bucket := d.buckets[hashIndex]
+	if bucket == nil {
+		return
+	}
+	d.buckets[hashIndex] = nil
+	bucket.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		op(hashValue)
+		return true
+	})
+}
+
+func (d *avltHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &treeIterator[K, V, *avltNode[K, V]]{
+		buckets: d.buckets,
+		child: func(node *avltNode[K, V], position int) *avltNode[K, V] {
+			if position == 0 {
+				return node.leftChild
+			}
+			return node.rightChild
+		},
+		value: func(node *avltNode[K, V], position int) *HashValue[K, V] {
+			if position == 0 {
+				return node.value
+			}
+			return nil
+		},
+	}
+}
+
+func (n *avltNode[K, V]) clone(parentNode *avltNode[K, V]) *avltNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	newNode := &avltNode[K, V]{
+		parentNode:  parentNode,
+		leftHeight:  n.leftHeight,
+		rightHeight: n.rightHeight,
+		value:       n.value,
+	}
+	newNode.leftChild, newNode.rightChild = n.leftChild.clone(newNode), n.rightChild.clone(newNode)
+	return newNode
+}
+
+func (d *avltHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, func(n *avltNode[K, V]) *avltNode[K, V] {
+		return n.clone(nil)
+	})
+}
+
+func (d *avltHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &avltHashMapData[K, V]{
+		buckets: d.buckets,
+	}
+}
+
+// list to tree - treeify
+
+// chain address like DLL, a bucket turns into an AVL tree once it is longer than TREEIFY_THRESHOLD,
+// and back into a list once it drops to UNTREEIFY_THRESHOLD
+
+const (
+	TREEIFY_THRESHOLD   = 8
+	UNTREEIFY_THRESHOLD = 6
+)
+
+type treeifyHashMapData[K Ordered, V any] struct {
+	lists  dllHashMapData[K, V]  // bucket while it is short
+	trees  avltHashMapData[K, V] // bucket after treeify, lists bucket is nil then
+	counts []int
+	shared bool // counts is shared with a snapshot, the next count change copies it
+}
+
+func (d *treeifyHashMapData[K, V]) Len() int {
+	return len(d.counts)
+}
+
+func (d *treeifyHashMapData[K, V]) isTree(hashIndex int) bool {
+	return d.trees.buckets[hashIndex] != nil
+}
+
+func (d *treeifyHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if d.isTree(hashIndex) {
+		return d.trees.Get(hashIndex, key)
+	}
+	return d.lists.Get(hashIndex, key)
+}
+
+func (d *treeifyHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	if d.isTree(hashIndex) {
+		if _, exists := d.trees.Get(hashIndex, hashValue.k); !exists {
+			d.addCount(hashIndex, 1)
+		}
+		return d.trees.Set(hashIndex, hashValue)
+	}
+	if _, exists := d.lists.Get(hashIndex, hashValue.k); !exists {
+		d.addCount(hashIndex, 1)
+	}
+	d.lists.Set(hashIndex, hashValue)
+	if d.counts[hashIndex] > TREEIFY_THRESHOLD {
+		d.treeify(hashIndex)
+	}
+	return true
+}
+
+func (d *treeifyHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if !d.isTree(hashIndex) {
+		value, ok := d.lists.Del(hashIndex, key)
+		if ok {
+			d.addCount(hashIndex, -1)
+		}
+		return value, ok
+	}
+	value, ok := d.trees.Del(hashIndex, key)
+	if ok {
+		d.addCount(hashIndex, -1)
+		if d.counts[hashIndex] <= UNTREEIFY_THRESHOLD {
+			d.untreeify(hashIndex)
+		}
+	}
+	return value, ok
+}
+
+func (d *treeifyHashMapData[K, V]) addCount(hashIndex, delta int) {
+	if d.shared {
+		d.counts, d.shared = append([]int(nil), d.counts...), false
+	}
+	d.counts[hashIndex] += delta
+}
+
+func (d *treeifyHashMapData[K, V]) treeify(hashIndex int) {
+	d.lists.Evacuate(hashIndex, func(hashValue *HashValue[K, V]) {
+		d.trees.Set(hashIndex, hashValue)
+	})
+}
+
+// untreeify 中序遍历，链表按键有序
+func (d *treeifyHashMapData[K, V]) untreeify(hashIndex int) {
+	d.trees.Evacuate(hashIndex, func(hashValue *HashValue[K, V]) {
+		d.lists.Set(hashIndex, hashValue)
+	})
+}
+
+func (d *treeifyHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for hashIndex := range d.counts {
+		for node := d.lists.buckets[hashIndex]; node != nil; node = node.nextNode {
+			if !op(node.value) {
+				return
+			}
+		}
+		if d.isTree(hashIndex) && !d.trees.buckets[hashIndex].inOrderTraversal(op) {
+			return
+		}
+	}
+}
+
+func (d *treeifyHashMapData[K, V]) validateCheck() {
+	d.trees.validateCheck()
+}
+
+func (d *treeifyHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.counts)) == size {
+		return true
+	}
+	newData := d.allocate(size)
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	*d = *newData
+	return true
+}
+
+func (d *treeifyHashMapData[K, V]) allocate(size uint) *treeifyHashMapData[K, V] {
+	return &treeifyHashMapData[K, V]{
+		lists: dllHashMapData[K, V]{
+			buckets: make([]*dllNode[K, V], size),
+		},
+		trees: avltHashMapData[K, V]{
+			buckets: make([]*avltNode[K, V], size),
+		},
+		counts: make([]int, size),
+	}
+}
+
+func (d *treeifyHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
+
+func (d *treeifyHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.lists.Evacuate(hashIndex, op)
+	d.trees.Evacuate(hashIndex, op)
+	d.addCount(hashIndex, -d.counts[hashIndex])
+}
+
+func (d *treeifyHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &chainIterator[K, V]{
+		iterators: []HashMapDataIterator[K, V]{d.lists.Iterator(), d.trees.Iterator()},
+	}
+}
+
+// Snapshot shares the list and tree buckets by their own copy on write, and counts until the next count change
+func (d *treeifyHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.shared = true
+	return &treeifyHashMapData[K, V]{
+		lists:  *d.lists.Snapshot().(*dllHashMapData[K, V]),
+		trees:  *d.trees.Snapshot().(*avltHashMapData[K, V]),
+		counts: d.counts,
+	}
+}
+
+// red-black tree - RBT
+
+type rbtNode[K Ordered, V any] struct {
+	parentNode *rbtNode[K, V]
+	leftChild  *rbtNode[K, V]
+	rightChild *rbtNode[K, V]
+	red        bool
+	value      *HashValue[K, V]
+}
+
+func (n *rbtNode[K, V]) isRed() bool {
+	return n != nil && n.red
+}
+
+func (n *rbtNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.leftChild.inOrderTraversal(op) && op(n.value) && n.rightChild.inOrderTraversal(op)
+}
+
+func (n *rbtNode[K, V]) minimum() *rbtNode[K, V] {
+	for n.leftChild != nil {
+		n = n.leftChild
+	}
+	return n
+}
+
+// checkInvariant 检查红节点没有红子节点、每条路径黑高相同，返回黑高
+func (n *rbtNode[K, V]) checkInvariant() int {
+	if n == nil {
+		return 1
+	}
+	if n.red && (n.leftChild.isRed() || n.rightChild.isRed()) {
+		panic(fmt.Sprintf("red node %v has red child\n", n.value.k))
+	}
+	for _, child := range []*rbtNode[K, V]{n.leftChild, n.rightChild} {
+		if child != nil && child.parentNode != n {
+			panic(fmt.Sprintf("node %v child %v parent is wrong\n", n.value.k, child.value.k))
+		}
+	}
+	if n.leftChild != nil && !(n.leftChild.value.k < n.value.k) || n.rightChild != nil && !(n.value.k < n.rightChild.value.k) {
+		panic(fmt.Sprintf("node %v children out of order\n", n.value.k))
+	}
+	leftBlackHeight, rightBlackHeight := n.leftChild.checkInvariant(), n.rightChild.checkInvariant()
+	if leftBlackHeight != rightBlackHeight {
+		panic(fmt.Sprintf("node %v left black height %v != right black height %v\n", n.value.k, leftBlackHeight, rightBlackHeight))
+	}
+	if n.red {
+		return leftBlackHeight
+	}
+	return leftBlackHeight + 1
+}
+
+// rbtTree 旋转与修复都可能改变根，统一通过根指针操作
+type rbtTree[K Ordered, V any] struct {
+	root **rbtNode[K, V]
+}
+
+func (t rbtTree[K, V]) replace(node, newNode *rbtNode[K, V]) {
+	if node.parentNode == nil {
+		*t.root = newNode
+	} else if node.parentNode.leftChild == node {
+		node.parentNode.leftChild = newNode
+	} else {
+		node.parentNode.rightChild = newNode
+	}
+	if newNode != nil {
+		newNode.parentNode = node.parentNode
+	}
+}
+
+func (t rbtTree[K, V]) leftRotate(node *rbtNode[K, V]) {
+	newRootNode := node.rightChild
+	node.rightChild = newRootNode.leftChild
+	if newRootNode.leftChild != nil {
+		newRootNode.leftChild.parentNode = node
+	}
+	t.replace(node, newRootNode)
+	newRootNode.leftChild = node
+	node.parentNode = newRootNode
+}
+
+func (t rbtTree[K, V]) rightRotate(node *rbtNode[K, V]) {
+	newRootNode := node.leftChild
+	node.leftChild = newRootNode.rightChild
+	if newRootNode.rightChild != nil {
+		newRootNode.rightChild.parentNode = node
+	}
+	t.replace(node, newRootNode)
+	newRootNode.rightChild = node
+	node.parentNode = newRootNode
+}
+
+// insertFixup 至多两次旋转
+func (t rbtTree[K, V]) insertFixup(node *rbtNode[K, V]) {
+	for node.parentNode.isRed() {
+		parentNode, grandNode := node.parentNode, node.parentNode.parentNode
+		if parentNode == grandNode.leftChild {
+			if uncleNode := grandNode.rightChild; uncleNode.isRed() {
+				parentNode.red, uncleNode.red, grandNode.red = false, false, true
+				node = grandNode
+				continue
+			}
+			if node == parentNode.rightChild {
+				node, parentNode = parentNode, node
+				t.leftRotate(node)
+			}
+			parentNode.red, grandNode.red = false, true
+			t.rightRotate(grandNode)
+		} else {
+			if uncleNode := grandNode.leftChild; uncleNode.isRed() {
+				parentNode.red, uncleNode.red, grandNode.red = false, false, true
+				node = grandNode
+				continue
+			}
+			if node == parentNode.leftChild {
+				node, parentNode = parentNode, node
+				t.rightRotate(node)
+			}
+			parentNode.red, grandNode.red = false, true
+			t.leftRotate(grandNode)
+		}
+	}
+	(*t.root).red = false
+}
+
+// deleteFixup 至多三次旋转，node 可能为空所以单独传入父节点
+func (t rbtTree[K, V]) deleteFixup(node, parentNode *rbtNode[K, V]) {
+	for node != *t.root && !node.isRed() {
+		if node == parentNode.leftChild {
+			siblingNode := parentNode.rightChild
+			if siblingNode.isRed() {
+				siblingNode.red, parentNode.red = false, true
+				t.leftRotate(parentNode)
+				siblingNode = parentNode.rightChild
+			}
+			if !siblingNode.leftChild.isRed() && !siblingNode.rightChild.isRed() {
+				siblingNode.red = true
+				node, parentNode = parentNode, parentNode.parentNode
+				continue
+			}
+			if !siblingNode.rightChild.isRed() {
+				siblingNode.leftChild.red, siblingNode.red = false, true
+				t.rightRotate(siblingNode)
+				siblingNode = parentNode.rightChild
+			}
+			siblingNode.red, parentNode.red, siblingNode.rightChild.red = parentNode.red, false, false
+			t.leftRotate(parentNode)
+		} else {
+			siblingNode := parentNode.leftChild
+			if siblingNode.isRed() {
+				siblingNode.red, parentNode.red = false, true
+				t.rightRotate(parentNode)
+				siblingNode = parentNode.leftChild
+			}
+			if !siblingNode.leftChild.isRed() && !siblingNode.rightChild.isRed() {
+				siblingNode.red = true
+				node, parentNode = parentNode, parentNode.parentNode
+				continue
+			}
+			if !siblingNode.leftChild.isRed() {
+				siblingNode.rightChild.red, siblingNode.red = false, true
+				t.leftRotate(siblingNode)
+				siblingNode = parentNode.leftChild
+			}
+			siblingNode.red, parentNode.red, siblingNode.leftChild.red = parentNode.red, false, false
+			t.rightRotate(parentNode)
+		}
+		node = *t.root
+	}
+	if node != nil {
+		node.red = false
+	}
+}
+
+func (t rbtTree[K, V]) delete(node *rbtNode[K, V]) {
+	var fixNode, fixParentNode *rbtNode[K, V]
+	removeRed := node.red
+	if node.leftChild == nil {
+		fixNode, fixParentNode = node.rightChild, node.parentNode
+		t.replace(node, node.rightChild)
+	} else if node.rightChild == nil {
+		fixNode, fixParentNode = node.leftChild, node.parentNode
+		t.replace(node, node.leftChild)
+	} else {
+		// 右子树最小节点替换删除节点
+		minRightNode := node.rightChild.minimum()
+		removeRed = minRightNode.red
+		fixNode = minRightNode.rightChild
+		if minRightNode.parentNode == node {
+			fixParentNode = minRightNode
+		} else {
+			fixParentNode = minRightNode.parentNode
+			t.replace(minRightNode, minRightNode.rightChild)
+			minRightNode.rightChild = node.rightChild
+			minRightNode.rightChild.parentNode = minRightNode
+		}
+		t.replace(node, minRightNode)
+		minRightNode.leftChild = node.leftChild
+		minRightNode.leftChild.parentNode = minRightNode
+		minRightNode.red = node.red
+	}
+	node.parentNode, node.leftChild, node.rightChild = nil, nil, nil
+	if !removeRed {
+		t.deleteFixup(fixNode, fixParentNode)
+	}
+}
+
+type rbtHashMapData[K Ordered, V any] struct {
+	buckets []*rbtNode[K, V]
+	cow     copyOnWrite
+}
+
+func (d *rbtHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *rbtHashMapData[K, V]) find(hashIndex int, key K) *rbtNode[K, V] {
+	node := d.buckets[hashIndex]
+	for node != nil {
+		if key < node.value.k {
+			node = node.leftChild
+		} else if node.value.k < key {
+			node = node.rightChild
+		} else {
+			return node
+		}
+	}
+	return nil
+}
+
+func (d *rbtHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if node := d.find(hashIndex, key); node != nil {
+		return node.value.v, true
+	}
+	return *new(V), false
+}
+
+func (d *rbtHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	var parentNode *rbtNode[K, V]
+	for node := d.buckets[hashIndex]; node != nil; {
+		parentNode = node
+		if hashValue.k < node.value.k {
+			node = node.leftChild
+		} else if node.value.k < hashValue.k {
+			node = node.rightChild
+		} else {
+			node.value = hashValue
+			return true
+		}
+	}
+	vNode := &rbtNode[K, V]{
+		parentNode: parentNode,
+		red:        true,
+		value:      hashValue,
+	}
+	if parentNode == nil {
+		d.buckets[hashIndex] = vNode
+	} else if hashValue.k < parentNode.value.k {
+		parentNode.leftChild = vNode
+	} else {
+		parentNode.rightChild = vNode
+	}
+	rbtTree[K, V]{root: &d.buckets[hashIndex]}.insertFixup(vNode)
+	return true
+}
+
+func (d *rbtHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	node := d.find(hashIndex, key)
+	if node == nil {
+		return *new(V), false
+	}
+	value := node.value.v
+	rbtTree[K, V]{root: &d.buckets[hashIndex]}.delete(node)
+	return value, true
+}
+
+func (d *rbtHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		if !bucket.inOrderTraversal(op) {
+			return
+		}
+	}
+}
+
+// validateCheck 检查根为黑以及红黑树性质
+func (d *rbtHashMapData[K, V]) validateCheck() {
+	for _, bucket := range d.buckets {
+		if bucket == nil {
+			continue
+		}
+		if bucket.red || bucket.parentNode != nil {
+			panic(fmt.Sprintf("root node %v is red or has parent\n", bucket.value.k))
+		}
+		bucket.checkInvariant()
+	}
+}
+
+func (d *rbtHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := &rbtHashMapData[K, V]{
+		buckets: make([]*rbtNode[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets, d.cow = newData.buckets, copyOnWrite{}
+	return true
+}
+
+func (d *rbtHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &rbtHashMapData[K, V]{
+		buckets: make([]*rbtNode[K, V], size),
+	}
+}
+
+func (d *rbtHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.own(hashIndex)
+	bucket := d.buckets[hashIndex]
+	d.buckets[hashIndex] = nil
+	bucket.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		op(hashValue)
+		return true
+	})
+}
+
+func (d *rbtHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &treeIterator[K, V, *rbtNode[K, V]]{
+		buckets: d.buckets,
+		child: func(node *rbtNode[K, V], position int) *rbtNode[K, V] {
+			if position == 0 {
+				return node.leftChild
+			}
+			return node.rightChild
+		},
+		value: func(node *rbtNode[K, V], position int) *HashValue[K, V] {
+			if position == 0 {
+				return node.value
+			}
+			return nil
+		},
+	}
+}
+
+func (n *rbtNode[K, V]) clone(parentNode *rbtNode[K, V]) *rbtNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	newNode := &rbtNode[K, V]{
+		parentNode: parentNode,
+		red:        n.red,
+		value:      n.value,
+	}
+	newNode.leftChild, newNode.rightChild = n.leftChild.clone(newNode), n.rightChild.clone(newNode)
+	return newNode
+}
+
+func (d *rbtHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, func(n *rbtNode[K, V]) *rbtNode[K, V] {
+		return n.clone(nil)
+	})
+}
+
+func (d *rbtHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &rbtHashMapData[K, V]{
+		buckets: d.buckets,
+	}
+}
+
+// skip list - SL
+
+// every bucket is a skip list ordered by key, no rotation needed
+
+const DEFAULT_SKIP_LIST_MAX_LEVEL = 16
+
+type skipListNode[K Ordered, V any] struct {
+	value     *HashValue[K, V]      // nil for the bucket head
+	nextNodes []*skipListNode[K, V] // next node of every level
+}
+
+type skipListHashMapData[K Ordered, V any] struct {
+	buckets  []*skipListNode[K, V] // bucket head, nil until the first Set
+	maxLevel int                   // DEFAULT_SKIP_LIST_MAX_LEVEL if zero
+	seed     int64                 // seed of random, level sequence is reproducible
+	random   *rand.Rand
+	cow      copyOnWrite
+}
+
+func (d *skipListHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *skipListHashMapData[K, V]) randomLevel(maxLevel int) int {
+	if d.random == nil {
+		d.random = rand.New(rand.NewSource(d.seed))
+	}
+	level := 1
+	for level < maxLevel && d.random.Intn(2) == 0 {
+		level++
+	}
+	return level
+}
+
+// search 自顶层向下查找，update 记录每层最后一个小于 key 的节点
+func (d *skipListHashMapData[K, V]) search(head *skipListNode[K, V], key K, update []*skipListNode[K, V]) *skipListNode[K, V] {
+	node := head
+	for level := len(head.nextNodes) - 1; level >= 0; level-- {
+		for next := node.nextNodes[level]; next != nil && next.value.k < key; next = node.nextNodes[level] {
+			node = next
+		}
+		if update != nil {
+			update[level] = node
+		}
+	}
+	if next := node.nextNodes[0]; next != nil && next.value.k == key {
+		return next
+	}
+	return nil
+}
+
+func (d *skipListHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if head := d.buckets[hashIndex]; head != nil {
+		if node := d.search(head, key, nil); node != nil {
+			return node.value.v, true
+		}
+	}
+	return *new(V), false
+}
+
+func (d *skipListHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	head := d.buckets[hashIndex]
+	if head == nil {
+		maxLevel := d.maxLevel
+		if maxLevel <= 0 {
+			maxLevel = DEFAULT_SKIP_LIST_MAX_LEVEL
+		}
+		head = &skipListNode[K, V]{
+			nextNodes: make([]*skipListNode[K, V], maxLevel),
+		}
+		d.buckets[hashIndex] = head
+	}
+	update := make([]*skipListNode[K, V], len(head.nextNodes))
+	if node := d.search(head, hashValue.k, update); node != nil {
+		node.value = hashValue
+		return true
+	}
+	vNode := &skipListNode[K, V]{
+		value:     hashValue,
+		nextNodes: make([]*skipListNode[K, V], d.randomLevel(len(head.nextNodes))),
+	}
+	for level := range vNode.nextNodes {
+		vNode.nextNodes[level] = update[level].nextNodes[level]
+		update[level].nextNodes[level] = vNode
+	}
+	return true
+}
+
+func (d *skipListHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	head := d.buckets[hashIndex]
+	update := make([]*skipListNode[K, V], len(head.nextNodes))
+	node := d.search(head, key, update)
+	for level := range node.nextNodes {
+		update[level].nextNodes[level] = node.nextNodes[level]
+	}
+	if head.nextNodes[0] == nil {
+		d.buckets[hashIndex] = nil
+	}
+	return node.value.v, true
+}
+
+func (d *skipListHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, head := range d.buckets {
+		if head == nil {
+			continue
+		}
+		for node := head.nextNodes[0]; node != nil; node = node.nextNodes[0] {
+			if !op(node.value) {
+				return
+			}
+		}
+	}
+}
+
+func (d *skipListHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := d.allocate(size)
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets, d.random, d.cow = newData.buckets, newData.random, copyOnWrite{}
+	return true
+}
+
+func (d *skipListHashMapData[K, V]) allocate(size uint) *skipListHashMapData[K, V] {
+	return &skipListHashMapData[K, V]{
+		buckets:  make([]*skipListNode[K, V], size),
+		maxLevel: d.maxLevel,
+		seed:     d.seed,
+		random:   d.random,
+	}
+}
+
+func (d *skipListHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
+
+func (d *skipListHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	head := d.buckets[hashIndex]
+	if head == nil {
+		return
+	}
+	d.own(hashIndex)
+	d.buckets[hashIndex] = nil
+	for node := head.nextNodes[0]; node != nil; node = node.nextNodes[0] {
+		op(node.value)
+	}
+}
+
+func (d *skipListHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	buckets := d.buckets
+	var node *skipListNode[K, V]
+	return &bucketIterator[K, V]{
+		length: len(buckets),
+		next: func(index int) *HashValue[K, V] {
+			if node == nil {
+				node = buckets[index]
+			}
+			if node != nil {
+				node = node.nextNodes[0]
+			}
+			if node == nil {
+				return nil
+			}
+			return node.value
+		},
+	}
+}
+
+// clone copies the whole list with the level of every node, the values are shared
+func (n *skipListNode[K, V]) clone() *skipListNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	head := &skipListNode[K, V]{
+		nextNodes: make([]*skipListNode[K, V], len(n.nextNodes)),
+	}
+	last := make([]*skipListNode[K, V], len(head.nextNodes)) // 每层最后复制的节点
+	for level := range last {
+		last[level] = head
+	}
+	for node := n.nextNodes[0]; node != nil; node = node.nextNodes[0] {
+		copyNode := &skipListNode[K, V]{
+			value:     node.value,
+			nextNodes: make([]*skipListNode[K, V], len(node.nextNodes)),
+		}
+		for level := range copyNode.nextNodes {
+			last[level].nextNodes[level] = copyNode
+			last[level] = copyNode
+		}
+	}
+	return head
+}
+
+func (d *skipListHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, (*skipListNode[K, V]).clone)
+}
+
+func (d *skipListHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &skipListHashMapData[K, V]{
+		buckets:  d.buckets,
+		maxLevel: d.maxLevel,
+		seed:     d.seed,
+	}
+}
+
+// splay tree - ST
+
+// every Get/Set/Del splays the key to the bucket root, hot keys stay near the root
+
+type stNode[K Ordered, V any] struct {
+	leftChild  *stNode[K, V]
+	rightChild *stNode[K, V]
+	value      *HashValue[K, V]
+}
+
+func (n *stNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.leftChild.inOrderTraversal(op) && op(n.value) && n.rightChild.inOrderTraversal(op)
+}
+
+// splay 自顶向下伸展，返回的新根为 key 所在节点或者查找路径上最后一个节点
+func (n *stNode[K, V]) splay(key K) *stNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	var header stNode[K, V]
+	leftTreeMax, rightTreeMin := &header, &header
+	for {
+		if key < n.value.k {
+			if n.leftChild == nil {
+				break
+			}
+			if key < n.leftChild.value.k { // zig-zig 右旋
+				leftChild := n.leftChild
+				n.leftChild, leftChild.rightChild = leftChild.rightChild, n
+				n = leftChild
+				if n.leftChild == nil {
+					break
+				}
+			}
+			rightTreeMin.leftChild, rightTreeMin = n, n
+			n = n.leftChild
+		} else if n.value.k < key {
+			if n.rightChild == nil {
+				break
+			}
+			if n.rightChild.value.k < key { // zag-zag 左旋
+				rightChild := n.rightChild
+				n.rightChild, rightChild.leftChild = rightChild.leftChild, n
+				n = rightChild
+				if n.rightChild == nil {
+					break
+				}
+			}
+			leftTreeMax.rightChild, leftTreeMax = n, n
+			n = n.rightChild
+		} else {
+			break
+		}
+	}
+	leftTreeMax.rightChild, rightTreeMin.leftChild = n.leftChild, n.rightChild
+	n.leftChild, n.rightChild = header.rightChild, header.leftChild
+	return n
+}
+
+type stHashMapData[K Ordered, V any] struct {
+	buckets []*stNode[K, V]
+}
+
+func (d *stHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+// MutatesOnGet marks stHashMapData as a mutatingGetter, Get splays the found node to the root
+func (d *stHashMapData[K, V]) MutatesOnGet() {}
+
+func (d *stHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	d.buckets[hashIndex] = d.buckets[hashIndex].splay(key)
+	if root := d.buckets[hashIndex]; root != nil && root.value.k == key {
+		return root.value.v, true
+	}
+	return *new(V), false
+}
+
+func (d *stHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	root := d.buckets[hashIndex].splay(hashValue.k)
+	if root != nil && root.value.k == hashValue.k {
+		root.value = hashValue
+		d.buckets[hashIndex] = root
+		return true
+	}
+	vNode := &stNode[K, V]{
+		value: hashValue,
+	}
+	if root != nil {
+		if hashValue.k < root.value.k {
+			vNode.leftChild, vNode.rightChild, root.leftChild = root.leftChild, root, nil
+		} else {
+			vNode.leftChild, vNode.rightChild, root.rightChild = root, root.rightChild, nil
+		}
+	}
+	d.buckets[hashIndex] = vNode
+	return true
+}
+
+func (d *stHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	root := d.buckets[hashIndex].splay(key)
+	d.buckets[hashIndex] = root
+	if root == nil || root.value.k != key {
+		return *new(V), false
+	}
+	if root.leftChild == nil {
+		d.buckets[hashIndex] = root.rightChild
+	} else {
+		// key 大于左子树全部节点，伸展后左子树最大节点为根且没有右子树
+		newRootNode := root.leftChild.splay(key)
+		newRootNode.rightChild = root.rightChild
+		d.buckets[hashIndex] = newRootNode
+	}
+	return root.value.v, true
+}
+
+func (d *stHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		if !bucket.inOrderTraversal(op) {
+			return
+		}
+	}
+}
+
+func (d *stHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := &stHashMapData[K, V]{
+		buckets: make([]*stNode[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets = newData.buckets
+	return true
+}
+
+func (d *stHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &stHashMapData[K, V]{
+		buckets: make([]*stNode[K, V], size),
+	}
+}
+
+func (d *stHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	bucket := d.buckets[hashIndex]
+	d.buckets[hashIndex] = nil
+	bucket.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		op(hashValue)
+		return true
+	})
+}
+
+func (d *stHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &treeIterator[K, V, *stNode[K, V]]{
+		buckets: d.buckets,
+		child: func(node *stNode[K, V], position int) *stNode[K, V] {
+			if position == 0 {
+				return node.leftChild
+			}
+			return node.rightChild
+		},
+		value: func(node *stNode[K, V], position int) *HashValue[K, V] {
+			if position == 0 {
+				return node.value
+			}
+			return nil
+		},
+	}
+}
+
+// treap - TP
+
+// binary search tree by key and heap by random priority
+
+type tpNode[K Ordered, V any] struct {
+	leftChild  *tpNode[K, V]
+	rightChild *tpNode[K, V]
+	priority   int64
+	value      *HashValue[K, V]
+}
+
+func (n *tpNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.leftChild.inOrderTraversal(op) && op(n.value) && n.rightChild.inOrderTraversal(op)
+}
+
+func (n *tpNode[K, V]) leftRotate() *tpNode[K, V] {
+	newRootNode := n.rightChild
+	n.rightChild, newRootNode.leftChild = newRootNode.leftChild, n
+	return newRootNode
+}
+
+func (n *tpNode[K, V]) rightRotate() *tpNode[K, V] {
+	newRootNode := n.leftChild
+	n.leftChild, newRootNode.rightChild = newRootNode.rightChild, n
+	return newRootNode
+}
+
+// insert 按键插入叶子，优先级大于父节点则向上旋转
+func (n *tpNode[K, V]) insert(vNode *tpNode[K, V]) *tpNode[K, V] {
+	if n == nil {
+		return vNode
+	}
+	if vNode.value.k < n.value.k {
+		n.leftChild = n.leftChild.insert(vNode)
+		if n.leftChild.priority > n.priority {
+			return n.rightRotate()
+		}
+	} else if n.value.k < vNode.value.k {
+		n.rightChild = n.rightChild.insert(vNode)
+		if n.rightChild.priority > n.priority {
+			return n.leftRotate()
+		}
+	} else {
+		n.value = vNode.value
+	}
+	return n
+}
+
+// merge 合并左右子树，左子树全部小于右子树
+func (n *tpNode[K, V]) merge(rightNode *tpNode[K, V]) *tpNode[K, V] {
+	if n == nil {
+		return rightNode
+	}
+	if rightNode == nil {
+		return n
+	}
+	if n.priority > rightNode.priority {
+		n.rightChild = n.rightChild.merge(rightNode)
+		return n
+	}
+	rightNode.leftChild = n.merge(rightNode.leftChild)
+	return rightNode
+}
+
+// delete 以左右子树合并结果替换删除节点
+func (n *tpNode[K, V]) delete(key K) (*tpNode[K, V], *HashValue[K, V]) {
+	if n == nil {
+		return nil, nil
+	}
+	var hashValue *HashValue[K, V]
+	if key < n.value.k {
+		n.leftChild, hashValue = n.leftChild.delete(key)
+	} else if n.value.k < key {
+		n.rightChild, hashValue = n.rightChild.delete(key)
+	} else {
+		return n.leftChild.merge(n.rightChild), n.value
+	}
+	return n, hashValue
+}
+
+type tpHashMapData[K Ordered, V any] struct {
+	buckets []*tpNode[K, V]
+	seed    int64 // seed of random, priority sequence is reproducible
+	random  *rand.Rand
+	cow     copyOnWrite
+}
+
+func (d *tpHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *tpHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	for node := d.buckets[hashIndex]; node != nil; {
+		if key < node.value.k {
+			node = node.leftChild
+		} else if node.value.k < key {
+			node = node.rightChild
+		} else {
+			return node.value.v, true
+		}
+	}
+	return *new(V), false
+}
+
+func (d *tpHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	if d.random == nil {
+		d.random = rand.New(rand.NewSource(d.seed))
+	}
+	d.buckets[hashIndex] = d.buckets[hashIndex].insert(&tpNode[K, V]{
+		priority: d.random.Int63(),
+		value:    hashValue,
+	})
+	return true
+}
+
+func (d *tpHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	var hashValue *HashValue[K, V]
+	d.buckets[hashIndex], hashValue = d.buckets[hashIndex].delete(key)
+	if hashValue == nil {
+		return *new(V), false
+	}
+	return hashValue.v, true
+}
+
+func (d *tpHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		if !bucket.inOrderTraversal(op) {
+			return
+		}
+	}
+}
+
+func (d *tpHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := d.allocate(size)
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets, d.random, d.cow = newData.buckets, newData.random, copyOnWrite{}
+	return true
+}
+
+func (d *tpHashMapData[K, V]) allocate(size uint) *tpHashMapData[K, V] {
+	return &tpHashMapData[K, V]{
+		buckets: make([]*tpNode[K, V], size),
+		seed:    d.seed,
+		random:  d.random,
+	}
+}
+
+func (d *tpHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
+
+func (d *tpHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.own(hashIndex)
+	bucket := d.buckets[hashIndex]
+	d.buckets[hashIndex] = nil
+	bucket.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		op(hashValue)
+		return true
+	})
+}
+
+func (d *tpHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &treeIterator[K, V, *tpNode[K, V]]{
+		buckets: d.buckets,
+		child: func(node *tpNode[K, V], position int) *tpNode[K, V] {
+			if position == 0 {
+				return node.leftChild
+			}
+			return node.rightChild
+		},
+		value: func(node *tpNode[K, V], position int) *HashValue[K, V] {
+			if position == 0 {
+				return node.value
+			}
+			return nil
+		},
+	}
+}
+
+func (n *tpNode[K, V]) clone() *tpNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	return &tpNode[K, V]{
+		leftChild:  n.leftChild.clone(),
+		rightChild: n.rightChild.clone(),
+		priority:   n.priority,
+		value:      n.value,
+	}
+}
+
+func (d *tpHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, (*tpNode[K, V]).clone)
+}
+
+func (d *tpHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &tpHashMapData[K, V]{
+		buckets: d.buckets,
+		seed:    d.seed,
+	}
+}
+
+// ----------------------------------------------------------------
+
+// 2-3 tree - TTT
+
+type tttNode[K Ordered, V any] struct {
+	leftValue, rightValue              *HashValue[K, V]
+	leftChild, middleChild, rightChild *tttNode[K, V] // 子树
+	parentNode                         *tttNode[K, V]
+}
+
+func (n *tttNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n.leftChild != nil && !n.leftChild.inOrderTraversal(op) {
+		return false
+	}
+	if n.leftValue != nil && !op(n.leftValue) {
+		return false
+	}
+	if n.middleChild != nil && !n.middleChild.inOrderTraversal(op) {
+		return false
+	}
+	if n.rightValue != nil && !op(n.rightValue) {
+		return false
+	}
+	if n.rightChild != nil && !n.rightChild.inOrderTraversal(op) {
+		return false
+	}
+	return true
+}
+
+func (n *tttNode[K, V]) getKeyString() string {
+	var keyString string
+	if n.leftValue != nil {
+		keyString = fmt.Sprintf("%v", n.leftValue.k)
+	}
+	if n.rightValue != nil {
+		if len(keyString) == 0 {
+			keyString = fmt.Sprintf("%v", n.rightValue.k)
+		} else {
+			keyString = fmt.Sprintf("%v,%v", keyString, n.rightValue.k)
+		}
+	}
+	return keyString
+}
+
+func (n *tttNode[K, V]) validateCheck() {
+	if n.leftValue == nil && n.rightValue != nil {
+		panic(fmt.Sprintf("node %+v left value is nil but right value is not nil\n", n))
+	}
+	if n.leftValue == nil && (n.leftChild != nil || n.middleChild != nil) {
+		panic(fmt.Sprintf("node %+v left value is nil but left or middle child is not nil\n", n))
+	}
+	if n.rightValue == nil && n.rightChild != nil {
+		panic(fmt.Sprintf("node %+v right value is nil but right child is not nil\n", n))
+	}
+	if n.leftChild != nil {
+		n.leftChild.validateCheck()
+	}
+	if n.middleChild != nil {
+		n.middleChild.validateCheck()
 	}
 	if n.rightChild != nil {
-		n.rightChild.inOrderTraversal(op)
+		n.rightChild.validateCheck()
+	}
+}
+
+func (n *tttNode[K, V]) setLeftChild(child *tttNode[K, V]) {
+	n.leftChild = child
+	if child != nil {
+		child.parentNode = n
+	}
+}
+
+func (n *tttNode[K, V]) setMiddleChild(child *tttNode[K, V]) {
+	n.middleChild = child
+	if child != nil {
+		child.parentNode = n
+	}
+}
+
+func (n *tttNode[K, V]) setRightChild(child *tttNode[K, V]) {
+	n.rightChild = child
+	if child != nil {
+		child.parentNode = n
+	}
+}
+
+func (n *tttNode[K, V]) isLeaf() bool {
+	return n.leftChild == nil && n.middleChild == nil && n.rightChild == nil
+}
+
+// values 按顺序返回节点数据
+func (n *tttNode[K, V]) values() []*HashValue[K, V] {
+	values := make([]*HashValue[K, V], 0, 2)
+	if n.leftValue != nil {
+		values = append(values, n.leftValue)
+	}
+	if n.rightValue != nil {
+		values = append(values, n.rightValue)
+	}
+	return values
+}
+
+// children 按顺序返回子树
+func (n *tttNode[K, V]) children() []*tttNode[K, V] {
+	children := make([]*tttNode[K, V], 0, 3)
+	for _, child := range []*tttNode[K, V]{n.leftChild, n.middleChild, n.rightChild} {
+		if child != nil {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// reset 按顺序重写节点数据与子树
+func (n *tttNode[K, V]) reset(values []*HashValue[K, V], children []*tttNode[K, V]) {
+	n.leftValue, n.rightValue = nil, nil
+	if len(values) > 0 {
+		n.leftValue = values[0]
+	}
+	if len(values) > 1 {
+		n.rightValue = values[1]
+	}
+	n.leftChild, n.middleChild, n.rightChild = nil, nil, nil
+	for index, child := range children {
+		switch index {
+		case 0:
+			n.setLeftChild(child)
+		case 1:
+			n.setMiddleChild(child)
+		case 2:
+			n.setRightChild(child)
+		}
+	}
+}
+
+type tttHashMapData[K Ordered, V any] struct {
+	buckets []*tttNode[K, V]
+	cow     copyOnWrite
+}
+
+func (d *tttHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *tttHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if d.buckets[hashIndex] == nil {
+		return *new(V), false
+	} else {
+		node := d.buckets[hashIndex]
+		for {
+			if node.leftValue != nil {
+				switch {
+				case node.leftValue.k == key:
+					return node.leftValue.v, true
+				case key < node.leftValue.k:
+					if node.leftChild != nil {
+						node = node.leftChild
+						continue
+					} else {
+						return *new(V), false
+					}
+				}
+			}
+			if node.rightValue != nil {
+				switch {
+				case node.rightValue.k == key:
+					return node.rightValue.v, true
+				case node.rightValue.k < key:
+					if node.rightChild != nil {
+						node = node.rightChild
+						continue
+					} else {
+						return *new(V), false
+					}
+				}
+			}
+			if node.middleChild != nil {
+				node = node.middleChild
+			} else {
+				return *new(V), false
+			}
+		}
+	}
+}
+
+// Set 插入叶子，叶子溢出为 3 个数据时分裂，中间数据上移到父节点，直到父节点不溢出或者产生新根
+func (d *tttHashMapData[K, V]) Set(hashIndex int, insertHashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	if d.buckets[hashIndex] == nil {
+		d.buckets[hashIndex] = &tttNode[K, V]{
+			leftValue: insertHashValue,
+		}
+	} else {
+		node := d.buckets[hashIndex]
+		var values []*HashValue[K, V]
+		insertIndex := 0
+		for {
+			values, insertIndex = node.values(), 0
+			for index, hashValue := range values {
+				if hashValue.k == insertHashValue.k {
+					hashValue.v = insertHashValue.v
+					return true
+				} else if hashValue.k < insertHashValue.k {
+					insertIndex = index + 1
+				}
+			}
+			if node.isLeaf() {
+				break
+			}
+			node = node.children()[insertIndex]
+		}
+
+		values = append(values[:insertIndex], append([]*HashValue[K, V]{insertHashValue}, values[insertIndex:]...)...)
+		var children []*tttNode[K, V] // nil while splitting leaves
+		for len(values) == 3 {
+			leftNode, rightNode := &tttNode[K, V]{}, &tttNode[K, V]{}
+			if children == nil {
+				leftNode.reset(values[:1], nil)
+				rightNode.reset(values[2:], nil)
+			} else {
+				leftNode.reset(values[:1], children[:2])
+				rightNode.reset(values[2:], children[2:])
+			}
+			upValue, parentNode := values[1], node.parentNode
+			if parentNode == nil {
+				// root split, the tree grows one level
+				node.reset([]*HashValue[K, V]{upValue}, []*tttNode[K, V]{leftNode, rightNode})
+				values = nil
+				break
+			}
+			parentValues, parentChildren := parentNode.values(), parentNode.children()
+			childIndex := 0
+			for parentChildren[childIndex] != node {
+				childIndex++
+			}
+			values = append(parentValues[:childIndex], append([]*HashValue[K, V]{upValue}, parentValues[childIndex:]...)...)
+			children = append(parentChildren[:childIndex], append([]*tttNode[K, V]{leftNode, rightNode}, parentChildren[childIndex+1:]...)...)
+			node = parentNode
+		}
+		if values != nil {
+			node.reset(values, children)
+		}
+	}
+	return true
+}
+
+// Del 删除叶子中的数据，内部节点先与中序前驱交换到叶子；
+// 叶子变空后向上修复：兄弟为 3 节点则借一个数据，否则与兄弟合并并下拉父节点数据，根变空则树高减一
+func (d *tttHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	node := d.buckets[hashIndex]
+	position := -1
+	for node != nil {
+		values := node.values()
+		childIndex := 0
+		for index, hashValue := range values {
+			if hashValue.k == key {
+				position = index
+				break
+			} else if hashValue.k < key {
+				childIndex = index + 1
+			}
+		}
+		if position != -1 || node.isLeaf() {
+			break
+		}
+		node = node.children()[childIndex]
+	}
+	if position == -1 {
+		return *new(V), false
+	}
+
+	values := node.values()
+	value := values[position].v
+	if !node.isLeaf() {
+		// in-order predecessor is the biggest value of the left subtree, always in a leaf
+		leaf := node.children()[position]
+		for !leaf.isLeaf() {
+			children := leaf.children()
+			leaf = children[len(children)-1]
+		}
+		leafValues := leaf.values()
+		values[position] = leafValues[len(leafValues)-1]
+		node.reset(values, node.children())
+		node, values, position = leaf, leafValues, len(leafValues)-1
+	}
+
+	values = append(values[:position], values[position+1:]...)
+	node.reset(values, nil)
+	if len(values) == 0 {
+		d.fixHole(hashIndex, node, nil)
+	}
+	return value, true
+}
+
+// fixHole 修复没有数据、至多一个子树的节点
+func (d *tttHashMapData[K, V]) fixHole(hashIndex int, hole, child *tttNode[K, V]) {
+	for {
+		parentNode := hole.parentNode
+		if parentNode == nil {
+			// root collapse
+			d.buckets[hashIndex] = child
+			if child != nil {
+				child.parentNode = nil
+			}
+			return
+		}
+
+		parentValues, parentChildren := parentNode.values(), parentNode.children()
+		holeIndex := 0
+		for parentChildren[holeIndex] != hole {
+			holeIndex++
+		}
+		siblingIndex, separatorIndex := holeIndex-1, holeIndex-1
+		if holeIndex == 0 {
+			siblingIndex, separatorIndex = 1, 0
+		}
+		sibling := parentChildren[siblingIndex]
+		siblingValues, siblingChildren := sibling.values(), sibling.children()
+		var holeChildren []*tttNode[K, V]
+		if child != nil {
+			holeChildren = []*tttNode[K, V]{child}
+		}
+
+		if len(siblingValues) == 2 {
+			// borrow: sibling value goes up to parent, separator goes down to hole
+			holeValues := []*HashValue[K, V]{parentValues[separatorIndex]}
+			if siblingIndex < holeIndex {
+				parentValues[separatorIndex] = siblingValues[1]
+				siblingValues = siblingValues[:1]
+				if child != nil {
+					holeChildren = []*tttNode[K, V]{siblingChildren[2], child}
+					siblingChildren = siblingChildren[:2]
+				}
+			} else {
+				parentValues[separatorIndex] = siblingValues[0]
+				siblingValues = siblingValues[1:]
+				if child != nil {
+					holeChildren = []*tttNode[K, V]{child, siblingChildren[0]}
+					siblingChildren = siblingChildren[1:]
+				}
+			}
+			sibling.reset(siblingValues, siblingChildren)
+			hole.reset(holeValues, holeChildren)
+			parentNode.reset(parentValues, parentChildren)
+			return
+		}
+
+		// merge: separator comes down into sibling, which becomes a 3 node
+		if siblingIndex < holeIndex {
+			sibling.reset(
+				[]*HashValue[K, V]{siblingValues[0], parentValues[separatorIndex]},
+				append(siblingChildren, holeChildren...),
+			)
+		} else {
+			sibling.reset(
+				[]*HashValue[K, V]{parentValues[separatorIndex], siblingValues[0]},
+				append(holeChildren, siblingChildren...),
+			)
+		}
+		parentValues = append(parentValues[:separatorIndex], parentValues[separatorIndex+1:]...)
+		parentChildren = append(parentChildren[:holeIndex], parentChildren[holeIndex+1:]...)
+		if len(parentValues) != 0 {
+			parentNode.reset(parentValues, parentChildren)
+			return
+		}
+		// parent lost its only value, it is the next hole
+		hole, child = parentNode, sibling
+	}
+}
+
+func (d *tttHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		if bucket != nil && !bucket.inOrderTraversal(op) {
+			return
+		}
+	}
+}
+
+func (d *tttHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := &tttHashMapData[K, V]{
+		buckets: make([]*tttNode[K, V], size),
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets, d.cow = newData.buckets, copyOnWrite{}
+	return true
+}
+
+func (d *tttHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &tttHashMapData[K, V]{
+		buckets: make([]*tttNode[K, V], size),
+	}
+}
+
+func (d *tttHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.own(hashIndex)
+	bucket := d.buckets[hashIndex]
+	if bucket == nil {
+		return
+	}
+	d.buckets[hashIndex] = nil
+	bucket.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		op(hashValue)
+		return true
+	})
+}
+
+func (d *tttHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &treeIterator[K, V, *tttNode[K, V]]{
+		buckets: d.buckets,
+		child: func(node *tttNode[K, V], position int) *tttNode[K, V] {
+			switch position {
+			case 0:
+				return node.leftChild
+			case 1:
+				return node.middleChild
+			case 2:
+				return node.rightChild
+			}
+			return nil
+		},
+		value: func(node *tttNode[K, V], position int) *HashValue[K, V] {
+			switch position {
+			case 0:
+				return node.leftValue
+			case 1:
+				return node.rightValue
+			}
+			return nil
+		},
+	}
+}
+
+// clone 复制整棵树，Set 会直接修改已有的值，所以值也要复制
+func (n *tttNode[K, V]) clone() *tttNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	values, children := n.values(), n.children()
+	for index, hashValue := range values {
+		values[index] = &HashValue[K, V]{k: hashValue.k, v: hashValue.v}
+	}
+	for index, child := range children {
+		children[index] = child.clone()
+	}
+	newNode := &tttNode[K, V]{}
+	newNode.reset(values, children)
+	return newNode
+}
+
+func (d *tttHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, (*tttNode[K, V]).clone)
+}
+
+func (d *tttHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &tttHashMapData[K, V]{
+		buckets: d.buckets,
+	}
+}
+
+// B-tree - BT
+
+// every node holds degree-1 ~ 2*degree-1 keys in contiguous arrays, large degree keeps buckets shallow
+
+const DEFAULT_BTREE_MIN_DEGREE = 16
+
+type btreeNode[K Ordered, V any] struct {
+	keys     []K
+	values   []V
+	children []*btreeNode[K, V] // nil for leaf, otherwise len(keys)+1
+}
+
+func sliceInsert[T any](s []T, index int, v T) []T {
+	s = append(s, *new(T))
+	copy(s[index+1:], s[index:])
+	s[index] = v
+	return s
+}
+
+func sliceRemove[T any](s []T, index int) []T {
+	copy(s[index:], s[index+1:])
+	s[len(s)-1] = *new(T)
+	return s[:len(s)-1]
+}
+
+func newBTreeNode[K Ordered, V any](degree int, leaf bool) *btreeNode[K, V] {
+	node := &btreeNode[K, V]{
+		keys:   make([]K, 0, 2*degree-1),
+		values: make([]V, 0, 2*degree-1),
+	}
+	if !leaf {
+		node.children = make([]*btreeNode[K, V], 0, 2*degree)
+	}
+	return node
+}
+
+func (n *btreeNode[K, V]) isLeaf() bool {
+	return n.children == nil
+}
+
+// search 二分查找第一个不小于 key 的位置
+func (n *btreeNode[K, V]) search(key K) (int, bool) {
+	low, high := 0, len(n.keys)
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if n.keys[middle] < key {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low, low < len(n.keys) && n.keys[low] == key
+}
+
+func (n *btreeNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n == nil {
+		return true
+	}
+	for index, key := range n.keys {
+		if !n.isLeaf() && !n.children[index].inOrderTraversal(op) {
+			return false
+		}
+		if !op(&HashValue[K, V]{k: key, v: n.values[index]}) {
+			return false
+		}
+	}
+	return n.isLeaf() || n.children[len(n.keys)].inOrderTraversal(op)
+}
+
+// splitChild 将已满的第 index 个子节点从中间分裂，中间键上移到当前节点
+func (n *btreeNode[K, V]) splitChild(index, degree int) {
+	child := n.children[index]
+	rightNode := newBTreeNode[K, V](degree, child.isLeaf())
+	rightNode.keys = append(rightNode.keys, child.keys[degree:]...)
+	rightNode.values = append(rightNode.values, child.values[degree:]...)
+	if !child.isLeaf() {
+		rightNode.children = append(rightNode.children, child.children[degree:]...)
+		for i := degree; i != len(child.children); i++ {
+			child.children[i] = nil
+		}
+		child.children = child.children[:degree]
+	}
+	n.keys = sliceInsert(n.keys, index, child.keys[degree-1])
+	n.values = sliceInsert(n.values, index, child.values[degree-1])
+	n.children = sliceInsert(n.children, index+1, rightNode)
+	for i := degree - 1; i != len(child.values); i++ {
+		child.values[i] = *new(V)
+	}
+	child.keys, child.values = child.keys[:degree-1], child.values[:degree-1]
+}
+
+// insert 自顶向下插入，沿途预先分裂已满的子节点，当前节点保证未满
+func (n *btreeNode[K, V]) insert(hashValue *HashValue[K, V], degree int) {
+	for {
+		index, found := n.search(hashValue.k)
+		if found {
+			n.values[index] = hashValue.v
+			return
+		}
+		if n.isLeaf() {
+			n.keys = sliceInsert(n.keys, index, hashValue.k)
+			n.values = sliceInsert(n.values, index, hashValue.v)
+			return
+		}
+		if len(n.children[index].keys) == 2*degree-1 {
+			n.splitChild(index, degree)
+			if n.keys[index] == hashValue.k {
+				n.values[index] = hashValue.v
+				return
+			}
+			if n.keys[index] < hashValue.k {
+				index++
+			}
+		}
+		n = n.children[index]
+	}
+}
+
+// merge 将第 index 个键以及右兄弟合并进第 index 个子节点
+func (n *btreeNode[K, V]) merge(index int) {
+	leftChild, rightChild := n.children[index], n.children[index+1]
+	leftChild.keys = append(append(leftChild.keys, n.keys[index]), rightChild.keys...)
+	leftChild.values = append(append(leftChild.values, n.values[index]), rightChild.values...)
+	if !leftChild.isLeaf() {
+		leftChild.children = append(leftChild.children, rightChild.children...)
+	}
+	n.keys = sliceRemove(n.keys, index)
+	n.values = sliceRemove(n.values, index)
+	n.children = sliceRemove(n.children, index+1)
+}
+
+// borrowFromLeft 经过父节点从左兄弟借一个键给第 index 个子节点
+func (n *btreeNode[K, V]) borrowFromLeft(index int) {
+	child, sibling := n.children[index], n.children[index-1]
+	last := len(sibling.keys) - 1
+	child.keys = sliceInsert(child.keys, 0, n.keys[index-1])
+	child.values = sliceInsert(child.values, 0, n.values[index-1])
+	n.keys[index-1], n.values[index-1] = sibling.keys[last], sibling.values[last]
+	sibling.keys, sibling.values = sliceRemove(sibling.keys, last), sliceRemove(sibling.values, last)
+	if !child.isLeaf() {
+		child.children = sliceInsert(child.children, 0, sibling.children[last+1])
+		sibling.children = sliceRemove(sibling.children, last+1)
+	}
+}
+
+// borrowFromRight 经过父节点从右兄弟借一个键给第 index 个子节点
+func (n *btreeNode[K, V]) borrowFromRight(index int) {
+	child, sibling := n.children[index], n.children[index+1]
+	child.keys = append(child.keys, n.keys[index])
+	child.values = append(child.values, n.values[index])
+	n.keys[index], n.values[index] = sibling.keys[0], sibling.values[0]
+	sibling.keys, sibling.values = sliceRemove(sibling.keys, 0), sliceRemove(sibling.values, 0)
+	if !child.isLeaf() {
+		child.children = append(child.children, sibling.children[0])
+		sibling.children = sliceRemove(sibling.children, 0)
+	}
+}
+
+// delete 自顶向下删除，进入子节点前保证其至少有 degree 个键
+func (n *btreeNode[K, V]) delete(key K, degree int) (V, bool) {
+	index, found := n.search(key)
+	if n.isLeaf() {
+		if !found {
+			return *new(V), false
+		}
+		value := n.values[index]
+		n.keys, n.values = sliceRemove(n.keys, index), sliceRemove(n.values, index)
+		return value, true
+	}
+	if found {
+		value := n.values[index]
+		if leftChild := n.children[index]; len(leftChild.keys) >= degree {
+			// 以前驱替换后在左子树删除前驱
+			predecessor := leftChild
+			for !predecessor.isLeaf() {
+				predecessor = predecessor.children[len(predecessor.children)-1]
+			}
+			last := len(predecessor.keys) - 1
+			n.keys[index], n.values[index] = predecessor.keys[last], predecessor.values[last]
+			leftChild.delete(n.keys[index], degree)
+		} else if rightChild := n.children[index+1]; len(rightChild.keys) >= degree {
+			// 以后继替换后在右子树删除后继
+			successor := rightChild
+			for !successor.isLeaf() {
+				successor = successor.children[0]
+			}
+			n.keys[index], n.values[index] = successor.keys[0], successor.values[0]
+			rightChild.delete(n.keys[index], degree)
+		} else {
+			n.merge(index)
+			n.children[index].delete(key, degree)
+		}
+		return value, true
+	}
+	if len(n.children[index].keys) < degree {
+		if index > 0 && len(n.children[index-1].keys) >= degree {
+			n.borrowFromLeft(index)
+		} else if index < len(n.keys) && len(n.children[index+1].keys) >= degree {
+			n.borrowFromRight(index)
+		} else if index < len(n.keys) {
+			n.merge(index)
+		} else {
+			n.merge(index - 1)
+			index--
+		}
+	}
+	return n.children[index].delete(key, degree)
+}
+
+// validateCheck 检查键有序、键数量范围以及所有叶子深度相同，返回深度
+func (n *btreeNode[K, V]) validateCheck(degree int, root bool) int {
+	if len(n.keys) > 2*degree-1 || (!root && len(n.keys) < degree-1) || len(n.keys) != len(n.values) {
+		panic(fmt.Sprintf("node %v keys count out of range\n", n.keys))
+	}
+	for index := 1; index < len(n.keys); index++ {
+		if !(n.keys[index-1] < n.keys[index]) {
+			panic(fmt.Sprintf("node %v keys not ordered\n", n.keys))
+		}
+	}
+	if n.isLeaf() {
+		return 1
+	}
+	if len(n.children) != len(n.keys)+1 {
+		panic(fmt.Sprintf("node %v children count %v not match\n", n.keys, len(n.children)))
+	}
+	depth := -1
+	for index, child := range n.children {
+		if (index > 0 && !(n.keys[index-1] < child.keys[0])) || (index < len(n.keys) && !(child.keys[len(child.keys)-1] < n.keys[index])) {
+			panic(fmt.Sprintf("child %v out of parent %v key range\n", child.keys, n.keys))
+		}
+		childDepth := child.validateCheck(degree, false)
+		if depth != -1 && depth != childDepth {
+			panic(fmt.Sprintf("node %v leaf depth not equal\n", n.keys))
+		}
+		depth = childDepth
+	}
+	return depth + 1
+}
+
+type btreeHashMapData[K Ordered, V any] struct {
+	buckets []*btreeNode[K, V]
+	degree  int // minimum degree, DEFAULT_BTREE_MIN_DEGREE if less than 2
+	cow     copyOnWrite
+}
+
+func (d *btreeHashMapData[K, V]) minDegree() int {
+	if d.degree < 2 {
+		return DEFAULT_BTREE_MIN_DEGREE
+	}
+	return d.degree
+}
+
+func (d *btreeHashMapData[K, V]) Len() int {
+	return len(d.buckets)
+}
+
+func (d *btreeHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	for node := d.buckets[hashIndex]; node != nil; {
+		index, found := node.search(key)
+		if found {
+			return node.values[index], true
+		}
+		if node.isLeaf() {
+			break
+		}
+		node = node.children[index]
+	}
+	return *new(V), false
+}
+
+func (d *btreeHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.own(hashIndex)
+	degree := d.minDegree()
+	root := d.buckets[hashIndex]
+	if root == nil {
+		root = newBTreeNode[K, V](degree, true)
+		d.buckets[hashIndex] = root
+	} else if len(root.keys) == 2*degree-1 {
+		// 根满时树长高一层
+		newRootNode := newBTreeNode[K, V](degree, false)
+		newRootNode.children = append(newRootNode.children, root)
+		newRootNode.splitChild(0, degree)
+		root = newRootNode
+		d.buckets[hashIndex] = root
+	}
+	root.insert(hashValue, degree)
+	return true
+}
+
+func (d *btreeHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	if _, hasKey := d.Get(hashIndex, key); !hasKey {
+		return *new(V), false
+	}
+	d.own(hashIndex)
+	root := d.buckets[hashIndex]
+	if root == nil {
+		return *new(V), false
+	}
+	value, ok := root.delete(key, d.minDegree())
+	if len(root.keys) == 0 {
+		// 根被合并空时树降低一层
+		if root.isLeaf() {
+			d.buckets[hashIndex] = nil
+		} else {
+			d.buckets[hashIndex] = root.children[0]
+		}
+	}
+	return value, ok
+}
+
+func (d *btreeHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		if !bucket.inOrderTraversal(op) {
+			return
+		}
 	}
+}
+
+func (d *btreeHashMapData[K, V]) validateCheck() {
+	for _, bucket := range d.buckets {
+		if bucket != nil {
+			bucket.validateCheck(d.minDegree(), true)
+		}
+	}
+}
+
+func (d *btreeHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(len(d.buckets)) == size {
+		return true
+	}
+	newData := &btreeHashMapData[K, V]{
+		buckets: make([]*btreeNode[K, V], size),
+		degree:  d.degree,
+	}
+	if !reallocate[K, V](d.Range, newData, hasher) {
+		return false
+	}
+	d.buckets, d.cow = newData.buckets, copyOnWrite{}
 	return true
 }
 
-type bstHashMapData struct {
-	buckets []*bstNode
+func (d *btreeHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return &btreeHashMapData[K, V]{
+		buckets: make([]*btreeNode[K, V], size),
+		degree:  d.degree,
+	}
 }
 
-func (d *bstHashMapData) Len() int {
-	return len(d.buckets)
+func (d *btreeHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	d.own(hashIndex)
+	bucket := d.buckets[hashIndex]
+	d.buckets[hashIndex] = nil
+	bucket.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		op(hashValue)
+		return true
+	})
 }
 
-func (d *bstHashMapData) Get(hashIndex, key int) (int, bool) {
-	if d.buckets[hashIndex] == nil {
-		return 0, false
-	} else {
-		node := d.buckets[hashIndex]
-		for {
-			if key < node.value.k {
-				if node.leftChild == nil {
-					return 0, false
-				} else {
-					node = node.leftChild
-				}
-			} else if node.value.k < key {
-				if node.rightChild == nil {
-					return 0, false
-				} else {
-					node = node.rightChild
-				}
-			} else {
-				return node.value.v, true
+func (d *btreeHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	return &treeIterator[K, V, *btreeNode[K, V]]{
+		buckets: d.buckets,
+		child: func(node *btreeNode[K, V], position int) *btreeNode[K, V] {
+			if position < len(node.children) {
+				return node.children[position]
+			}
+			return nil
+		},
+		value: func(node *btreeNode[K, V], position int) *HashValue[K, V] {
+			if position < len(node.keys) {
+				return &HashValue[K, V]{k: node.keys[position], v: node.values[position]}
 			}
+			return nil
+		},
+	}
+}
+
+func (n *btreeNode[K, V]) clone() *btreeNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	newNode := &btreeNode[K, V]{
+		keys:   append(make([]K, 0, cap(n.keys)), n.keys...),
+		values: append(make([]V, 0, cap(n.values)), n.values...),
+	}
+	if !n.isLeaf() {
+		newNode.children = make([]*btreeNode[K, V], len(n.children), cap(n.children))
+		for index, child := range n.children {
+			newNode.children[index] = child.clone()
 		}
 	}
+	return newNode
 }
 
-func (d *bstHashMapData) Set(hashIndex int, hashValue *HashValue) bool {
-	vNode := &bstNode{
-		value: hashValue,
+func (d *btreeHashMapData[K, V]) own(hashIndex int) {
+	d.buckets = ownBucket(&d.cow, d.buckets, hashIndex, (*btreeNode[K, V]).clone)
+}
+
+func (d *btreeHashMapData[K, V]) Snapshot() HashMapData[K, V] {
+	d.cow.share()
+	return &btreeHashMapData[K, V]{
+		buckets: d.buckets,
+		degree:  d.degree,
 	}
-	if d.buckets[hashIndex] == nil {
-		d.buckets[hashIndex] = vNode
-	} else {
-		node := d.buckets[hashIndex]
-		for {
-			if hashValue.k < node.value.k {
-				if node.leftChild == nil {
-					node.leftChild = vNode
-					return true
-				} else {
-					node = node.leftChild
-				}
-			} else if node.value.k < hashValue.k {
-				if node.rightChild == nil {
-					node.rightChild = vNode
-					return true
-				} else {
-					node = node.rightChild
-				}
-			} else {
-				node.value = hashValue
+}
+
+// ----------------------------------------------------------------
+
+// dynamic hashing, data structure grows a bucket at a time and never rehashes as a whole
+
+// extendible hashing - EH
+
+// directory of 2^globalDepth entries addressed by the low bits of hash, buckets with localDepth < globalDepth are shared
+
+const DEFAULT_EXTENDIBLE_BUCKET_SIZE = 8
+
+type extendibleBucket[K comparable, V any] struct {
+	localDepth uint
+	values     []*HashValue[K, V]
+}
+
+type extendibleHashMapData[K comparable, V any] struct {
+	directory   []*extendibleBucket[K, V] // len(directory) == 1<<globalDepth
+	globalDepth uint
+	bucketSize  int       // DEFAULT_EXTENDIBLE_BUCKET_SIZE if zero
+	hasher      Hasher[K] // custom hasher handed over by Reallocate, nil for hash64
+}
+
+// hash addresses the directory by its own bits of the hasher, the hash index of HashMap is only a slot of the current directory
+func (d *extendibleHashMapData[K, V]) hash(key K) uint64 {
+	return dynamicHash(d.hasher, key)
+}
+
+func (d *extendibleHashMapData[K, V]) capacity() int {
+	if d.bucketSize <= 0 {
+		return DEFAULT_EXTENDIBLE_BUCKET_SIZE
+	}
+	return d.bucketSize
+}
+
+// initDirectory makes the zero value usable with a single bucket of depth 0
+func (d *extendibleHashMapData[K, V]) initDirectory() {
+	if len(d.directory) == 0 {
+		d.directory, d.globalDepth = []*extendibleBucket[K, V]{{}}, 0
+	}
+}
+
+func (d *extendibleHashMapData[K, V]) bucket(key K) (*extendibleBucket[K, V], int) {
+	d.initDirectory()
+	index := int(d.hash(key) & uint64(len(d.directory)-1))
+	return d.directory[index], index
+}
+
+func (d *extendibleHashMapData[K, V]) Len() int {
+	d.initDirectory()
+	return len(d.directory)
+}
+
+// SetLoadFactor is ignored, a bucket splits when it holds more than bucketSize values
+func (d *extendibleHashMapData[K, V]) SetLoadFactor(float64) {}
+
+func (d *extendibleHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	bucket, _ := d.bucket(key)
+	for _, hashValue := range bucket.values {
+		if hashValue.k == key {
+			return hashValue.v, true
+		}
+	}
+	return *new(V), false
+}
+
+func (d *extendibleHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	for {
+		bucket, _ := d.bucket(hashValue.k)
+		for index, value := range bucket.values {
+			if value.k == hashValue.k {
+				bucket.values[index] = hashValue
 				return true
 			}
 		}
+		// 哈希全部相同的键无法再分裂，只能超出容量
+		if len(bucket.values) < d.capacity() || d.sameHash(bucket, hashValue.k) {
+			bucket.values = append(bucket.values, hashValue)
+			return true
+		}
+		d.split(bucket)
+	}
+}
+
+// sameHash reports whether every key of the bucket has the same hash as key, a custom hasher may collide often
+func (d *extendibleHashMapData[K, V]) sameHash(bucket *extendibleBucket[K, V], key K) bool {
+	h := d.hash(key)
+	for _, hashValue := range bucket.values {
+		if d.hash(hashValue.k) != h {
+			return false
+		}
 	}
 	return true
 }
 
-// 移动左子树到右子树最小节点的左子树下（树易失衡）
-func (d *bstHashMapData) del(hashIndex, key int) (int, bool) {
-	if d.buckets[hashIndex] == nil {
-		return 0, true
-	} else {
-		var parentNode *bstNode
-		node := d.buckets[hashIndex]
-		for {
-			if key < node.value.k {
-				if node.leftChild == nil {
-					return 0, true
-				} else {
-					parentNode = node
-					node = node.leftChild
-				}
-			} else if node.value.k < key {
-				if node.rightChild == nil {
-					return 0, true
-				} else {
-					parentNode = node
-					node = node.rightChild
-				}
-			} else {
-				value := node.value.v
-				if parentNode == nil {
-					d.buckets[hashIndex] = nil
-				} else {
-					if parentNode.leftChild == node {
-						if node.rightChild == nil {
-							parentNode.leftChild = node.leftChild
-						} else {
-							parentNode.leftChild = node.rightChild
-							if node.leftChild != nil {
-								leftSubNode := node.leftChild
-								for node = node.rightChild; node.leftChild != nil; node = node.leftChild {
-								}
-								node.leftChild = leftSubNode
-							}
-						}
-					} else if parentNode.rightChild == node {
-						if node.rightChild == nil {
-							parentNode.rightChild = node.leftChild
-						} else {
-							parentNode.rightChild = node.rightChild
-							if node.leftChild != nil {
-								leftSubNode := node.leftChild
-								for node = node.rightChild; node.leftChild != nil; node = node.leftChild {
-								}
-								node.leftChild = leftSubNode
-							}
-						}
-					} else {
-						// TODO: BST error, need range and print tree
-						return 0, false
-					}
-				}
-				return value, true
+// split 只分裂溢出的桶，局部深度等于全局深度时目录先翻倍
+func (d *extendibleHashMapData[K, V]) split(bucket *extendibleBucket[K, V]) {
+	if bucket.localDepth == d.globalDepth {
+		d.directory = append(d.directory, d.directory...)
+		d.globalDepth++
+	}
+	bit := uint64(1) << bucket.localDepth
+	bucket.localDepth++
+	newBucket := &extendibleBucket[K, V]{
+		localDepth: bucket.localDepth,
+	}
+	values := bucket.values
+	bucket.values = make([]*HashValue[K, V], 0, d.capacity())
+	for _, hashValue := range values {
+		if d.hash(hashValue.k)&bit != 0 {
+			newBucket.values = append(newBucket.values, hashValue)
+		} else {
+			bucket.values = append(bucket.values, hashValue)
+		}
+	}
+	for index, entry := range d.directory {
+		if entry == bucket && uint64(index)&bit != 0 {
+			d.directory[index] = newBucket
+		}
+	}
+}
+
+func (d *extendibleHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	bucket, directoryIndex := d.bucket(key)
+	for index, hashValue := range bucket.values {
+		if hashValue.k == key {
+			last := len(bucket.values) - 1
+			bucket.values[index], bucket.values[last] = bucket.values[last], nil
+			bucket.values = bucket.values[:last]
+			d.merge(bucket, directoryIndex)
+			return hashValue.v, true
+		}
+	}
+	return *new(V), false
+}
+
+// merge 与局部深度相同的伙伴桶合计不超过半个桶时合并，然后尽量将目录减半
+func (d *extendibleHashMapData[K, V]) merge(bucket *extendibleBucket[K, V], directoryIndex int) {
+	for bucket.localDepth > 0 {
+		bit := 1 << (bucket.localDepth - 1)
+		buddy := d.directory[directoryIndex^bit]
+		if buddy.localDepth != bucket.localDepth || len(bucket.values)+len(buddy.values) > d.capacity()>>1 {
+			break
+		}
+		bucket.values = append(bucket.values, buddy.values...)
+		bucket.localDepth--
+		for index, entry := range d.directory {
+			if entry == buddy {
+				d.directory[index] = bucket
+			}
+		}
+		directoryIndex &= bit - 1
+	}
+	for d.globalDepth > 0 {
+		half := len(d.directory) >> 1
+		for index := 0; index != half; index++ {
+			if d.directory[index] != d.directory[index+half] {
+				return
 			}
 		}
+		d.directory = append([]*extendibleBucket[K, V](nil), d.directory[:half]...)
+		d.globalDepth--
 	}
 }
 
-// 删除匹配节点，移动右子树最小节点到匹配节点
-func (d *bstHashMapData) Del(hashIndex, key int) (int, bool) {
-	if d.buckets[hashIndex] == nil {
-		return 0, true
-	} else {
-		// fmt.Println()
-		// fmt.Printf("Before Delete %v preOrder\n", key)
-		// d.buckets[hashIndex].preOrderTraversal(func(h *HashValue) bool {
-		// 	fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
-		// 	return true
-		// }, 0)
-		var parentNode *bstNode
-		node := d.buckets[hashIndex]
-		for {
-			if key < node.value.k {
-				if node.leftChild == nil {
-					return 0, true
-				} else {
-					parentNode = node
-					node = node.leftChild
-				}
-			} else if node.value.k < key {
-				if node.rightChild == nil {
-					return 0, true
-				} else {
-					parentNode = node
-					node = node.rightChild
-				}
-			} else {
-				value, deleteNode := node.value.v, node
-				var newNode *bstNode
-				leftChild := node.leftChild
-				rightChild := node.rightChild
-				minRightNodeParentNode := node
-				for node = node.rightChild; node != nil && node.leftChild != nil; minRightNodeParentNode, node = node, node.leftChild {
-				}
-				if node == nil { // 单左链表
-					newNode = leftChild
-				} else if minRightNodeParentNode == deleteNode { // 单右链表
-					newNode = deleteNode.rightChild
-					newNode.leftChild = leftChild
-				} else if minRightNodeParentNode != node {
-					minRightNodeParentNode.leftChild = node.rightChild
-					node.leftChild = leftChild
-					node.rightChild = rightChild
-					newNode = node
-				} else {
-					newNode = node.rightChild
-				}
+// Range visits a shared bucket only from its lowest directory index
+func (d *extendibleHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for index, bucket := range d.directory {
+		if index >= 1<<bucket.localDepth {
+			continue
+		}
+		for _, hashValue := range bucket.values {
+			if !op(hashValue) {
+				return
+			}
+		}
+	}
+}
 
-				if parentNode == nil {
-					d.buckets[hashIndex] = newNode
-				} else if parentNode.leftChild == deleteNode {
-					parentNode.leftChild = newNode
-				} else if parentNode.rightChild == deleteNode {
-					parentNode.rightChild = newNode
-				} else {
-					// TODO: error
-					return 0, false
-				}
+// Reallocate rebuilds the directory with size rounded up to a power of two, each entry with its own bucket,
+// and addresses it by the hasher from now on, even at the same size
+func (d *extendibleHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := d.allocate(size)
+	newData.hasher = customHasher(hasher)
+	d.Range(func(hashValue *HashValue[K, V]) bool {
+		return newData.Set(0, hashValue)
+	})
+	d.directory, d.globalDepth, d.hasher = newData.directory, newData.globalDepth, newData.hasher
+	return true
+}
 
-				deleteNode.leftChild = nil
-				deleteNode.rightChild = nil
+func (d *extendibleHashMapData[K, V]) allocate(size uint) *extendibleHashMapData[K, V] {
+	globalDepth := uint(0)
+	if size > 1 {
+		globalDepth = uint(bits.Len(size - 1))
+	}
+	newData := &extendibleHashMapData[K, V]{
+		directory:   make([]*extendibleBucket[K, V], 1<<globalDepth),
+		globalDepth: globalDepth,
+		bucketSize:  d.bucketSize,
+		hasher:      d.hasher,
+	}
+	for index := range newData.directory {
+		newData.directory[index] = &extendibleBucket[K, V]{
+			localDepth: globalDepth,
+		}
+	}
+	return newData
+}
 
-				// if d.buckets[hashIndex] != nil {
-				// 	fmt.Printf("After Delete %v preOrder\n", key)
-				// 	d.buckets[hashIndex].preOrderTraversal(func(h *HashValue) bool {
-				// 		fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
-				// 		return true
-				// 	}, 0)
-				// }
-				return value, true
+func (d *extendibleHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
+
+// Evacuate empties the whole bucket of the directory entry, entries sharing it find it empty later
+func (d *extendibleHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	bucket := d.directory[hashIndex]
+	values := bucket.values
+	bucket.values = nil
+	for _, hashValue := range values {
+		op(hashValue)
+	}
+}
+
+// Iterator visits a shared bucket only from its lowest directory index like Range
+func (d *extendibleHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	directory := d.directory
+	valueIndex := -1
+	return &bucketIterator[K, V]{
+		length: len(directory),
+		next: func(index int) *HashValue[K, V] {
+			bucket := directory[index]
+			valueIndex++
+			if index >= 1<<bucket.localDepth || valueIndex >= len(bucket.values) {
+				valueIndex = -1
+				return nil
 			}
-		}
-		// fmt.Printf("DEBUG: search but not find!")
-		// d.buckets[hashIndex].inOrderTraversal(func(h *HashValue) bool {
-		// 	fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
-		// 	return true
-		// })
+			return bucket.values[valueIndex]
+		},
 	}
 }
 
-func (d *bstHashMapData) Range(op func(*HashValue) bool) {
-	for _, bucket := range d.buckets {
-		if bucket != nil {
-			fmt.Printf("inOrderTraversal\n")
-			if !bucket.inOrderTraversal(op) {
-				return
-			}
-			fmt.Printf("preOrderTraversal\n")
-			if !bucket.preOrderTraversal(op, 0) {
-				return
-			}
-		}
+// linear hashing - LH
+
+// Litwin's linear hashing, buckets below the split pointer are addressed by the next hash level,
+// exceeding the load factor splits the bucket at the split pointer and moves it on by one
+
+type linearHashMapData[K comparable, V any] struct {
+	buckets    [][]*HashValue[K, V]
+	initSize   int     // bucket count of level 0, len(buckets) at creation if zero
+	level      uint    // buckets [split, initSize<<level) are addressed by hash % (initSize<<level)
+	split      int     // next bucket to split, buckets [0, split) and their images are addressed by hash % (initSize<<(level+1))
+	count      int       // values stored
+	loadFactor float64   // handed over by HashMap, DEFAULT_LOAD_FACTOR if zero
+	hasher     Hasher[K] // custom hasher handed over by Reallocate, nil for hash64
+}
+
+func (d *linearHashMapData[K, V]) hash(key K) uint64 {
+	return dynamicHash(d.hasher, key)
+}
+
+// initBuckets makes the zero value usable with a single bucket
+func (d *linearHashMapData[K, V]) initBuckets() {
+	if len(d.buckets) == 0 {
+		d.buckets = make([][]*HashValue[K, V], 1)
+	}
+	if d.initSize == 0 {
+		d.initSize, d.level, d.split = len(d.buckets), 0, 0
 	}
 }
 
-func (d *bstHashMapData) Reallocate(size uint) {
-	if uint(len(d.buckets)) != size {
-		d.buckets = make([]*bstNode, size)
+// address 先按当前层取模，落在分裂指针之前的桶已经分裂，改用下一层取模
+func (d *linearHashMapData[K, V]) address(key K) int {
+	d.initBuckets()
+	h := d.hash(key)
+	index := int(h % uint64(d.initSize<<d.level))
+	if index < d.split {
+		index = int(h % uint64(d.initSize<<(d.level+1)))
 	}
-	// TODO: move data
+	return index
 }
 
-// avl tree - AVLT
+func (d *linearHashMapData[K, V]) Len() int {
+	d.initBuckets()
+	return len(d.buckets)
+}
 
-type avltNode struct {
-	parentNode  *avltNode
-	leftHeight  int
-	leftChild   *avltNode
-	rightHeight int
-	rightChild  *avltNode
-	value       *HashValue
+func (d *linearHashMapData[K, V]) SetLoadFactor(loadFactor float64) {
+	d.loadFactor = loadFactor
 }
 
-func (n *avltNode) preOrderTraversal(op func(*HashValue) bool, deep int) bool {
-	fmt.Printf("%v", strings.Repeat("\t", deep))
-	if !op(n.value) {
-		return false
-	}
-	if n.leftChild != nil {
-		n.leftChild.preOrderTraversal(op, deep+1)
-	}
-	if n.rightChild != nil {
-		n.rightChild.preOrderTraversal(op, deep+1)
+func (d *linearHashMapData[K, V]) maxLoadFactor() float64 {
+	if d.loadFactor <= 0 {
+		return DEFAULT_LOAD_FACTOR
 	}
-	return true
+	return d.loadFactor
 }
 
-func (n *avltNode) preOrderTraversalWithHeight(op func(*HashValue, int, int) bool, deep int) bool {
-	fmt.Printf("%v", strings.Repeat("\t", deep))
-	if !op(n.value, n.leftHeight, n.rightHeight) {
-		return false
+func (d *linearHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	for _, hashValue := range d.buckets[d.address(key)] {
+		if hashValue.k == key {
+			return hashValue.v, true
+		}
 	}
-	if n.leftChild != nil {
-		n.leftChild.preOrderTraversalWithHeight(op, deep+1)
+	return *new(V), false
+}
+
+func (d *linearHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	index := d.address(hashValue.k)
+	for valueIndex, value := range d.buckets[index] {
+		if value.k == hashValue.k {
+			d.buckets[index][valueIndex] = hashValue
+			return true
+		}
 	}
-	if n.rightChild != nil {
-		n.rightChild.preOrderTraversalWithHeight(op, deep+1)
+	d.buckets[index] = append(d.buckets[index], hashValue)
+	d.count++
+	for float64(d.count)/float64(len(d.buckets)) > d.maxLoadFactor() {
+		d.grow()
 	}
 	return true
 }
 
-func (n *avltNode) inOrderTraversal(op func(*HashValue) bool) bool {
-	if n.leftChild != nil {
-		n.leftChild.inOrderTraversal(op)
+// grow 分裂分裂指针处的桶，新桶追加到末尾，一层全部分裂完后进入下一层
+func (d *linearHashMapData[K, V]) grow() {
+	values := d.buckets[d.split]
+	d.buckets[d.split] = nil
+	d.buckets = append(d.buckets, nil)
+	d.split++
+	for _, hashValue := range values {
+		index := d.address(hashValue.k)
+		d.buckets[index] = append(d.buckets[index], hashValue)
 	}
-	if !op(n.value) {
-		return false
-	}
-	if n.rightChild != nil {
-		n.rightChild.inOrderTraversal(op)
+	if d.split == d.initSize<<d.level {
+		d.level++
+		d.split = 0
 	}
-	return true
 }
 
-// TODO: just check, no update -> rebalance just update & checkBalance just check
-// checkAndRebalance 向上检查平衡并且再平衡
-func (n *avltNode) checkAndRebalance(height int) *avltNode {
-	if n.parentNode == nil {
-		return nil
+func (d *linearHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	index := d.address(key)
+	bucket := d.buckets[index]
+	for valueIndex, hashValue := range bucket {
+		if hashValue.k == key {
+			last := len(bucket) - 1
+			bucket[valueIndex], bucket[last] = bucket[last], nil
+			d.buckets[index] = bucket[:last]
+			d.count--
+			for len(d.buckets) > d.initSize && float64(d.count)/float64(len(d.buckets)) < d.maxLoadFactor()*DEFAULT_SHRINK_RATIO {
+				d.shrink()
+			}
+			return hashValue.v, true
+		}
 	}
-	if n.parentNode.leftChild == n {
-		n.parentNode.leftHeight = height
-	} else if n.parentNode.rightChild == n {
-		n.parentNode.rightHeight = height
+	return *new(V), false
+}
+
+// shrink 是 grow 的逆过程，分裂指针后退一个桶，将最后一个桶合并回去
+func (d *linearHashMapData[K, V]) shrink() {
+	if d.split == 0 {
+		d.level--
+		d.split = d.initSize << d.level
 	}
-	if diff := n.parentNode.leftHeight - n.parentNode.rightHeight; diff < -1 || 1 < diff {
-		fmt.Printf("node %v lost balance\n", n.parentNode.value.k)
-		n.parentNode.preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-			fmt.Printf("DEBUG: range key: %v, value: %v, leftHeight = %v, rightHeight = %v\n", h.k, h.v, leftHeight, rightHeight)
-			return true
-		}, 0)
-		return n.parentNode
+	d.split--
+	last := len(d.buckets) - 1
+	d.buckets[d.split] = append(d.buckets[d.split], d.buckets[last]...)
+	d.buckets[last] = nil
+	d.buckets = d.buckets[:last]
+}
+
+func (d *linearHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	for _, bucket := range d.buckets {
+		for _, hashValue := range bucket {
+			if !op(hashValue) {
+				return
+			}
+		}
 	}
-	return n.parentNode.checkAndRebalance(height + 1)
 }
 
-// rebalance 向下再平衡
-func (n *avltNode) rebalance() int {
-	if n.leftChild != nil {
-		n.leftHeight = n.leftChild.rebalance() + 1
-	} else {
-		n.leftHeight = 0
+// Reallocate restarts level 0 with size buckets and addresses them by the hasher from now on, even at the same size
+func (d *linearHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	newData := d.allocate(size)
+	newData.hasher = customHasher(hasher)
+	d.Range(func(hashValue *HashValue[K, V]) bool {
+		return newData.Set(0, hashValue)
+	})
+	*d = *newData
+	return true
+}
+
+func (d *linearHashMapData[K, V]) allocate(size uint) *linearHashMapData[K, V] {
+	if size == 0 {
+		size = 1
 	}
-	if n.rightChild != nil {
-		n.rightHeight = n.rightChild.rebalance() + 1
-	} else {
-		n.rightHeight = 0
+	return &linearHashMapData[K, V]{
+		buckets:    make([][]*HashValue[K, V], size),
+		initSize:   int(size),
+		loadFactor: d.loadFactor,
+		hasher:     d.hasher,
 	}
-	return n.getHeight()
 }
 
-// checkBalance 向下检查平衡
-func (n *avltNode) checkBalance() *avltNode {
-	var leftLostBalanceNode, rightLostBalanceNode *avltNode
-	if n.leftChild != nil {
-		leftLostBalanceNode = n.leftChild.checkBalance()
+func (d *linearHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
+
+func (d *linearHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	values := d.buckets[hashIndex]
+	d.buckets[hashIndex] = nil
+	d.count -= len(values)
+	for _, hashValue := range values {
+		op(hashValue)
 	}
-	if n.rightChild != nil {
-		rightLostBalanceNode = n.rightChild.checkBalance()
-	}
-	if leftLostBalanceNode != nil && rightLostBalanceNode != nil {
-		fmt.Printf("node %v left %v and right %v node both lost balance\n", n.value.k, leftLostBalanceNode.value.k, rightLostBalanceNode.value.k)
-		panic(fmt.Sprintf("node %v left %v and right %v node both lost balance\n", n.value.k, leftLostBalanceNode.value.k, rightLostBalanceNode.value.k))
-	} else if leftLostBalanceNode != nil {
-		return leftLostBalanceNode
-	} else if rightLostBalanceNode != nil {
-		return rightLostBalanceNode
-	}
-	if diff := n.leftHeight - n.rightHeight; diff < -1 || 1 < diff {
-		// fmt.Printf("DEBUG: checkBalance node %v lost balance\n", n.value.k)
-		// n.preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-		// 	fmt.Printf("DEBUG: range key: %v, value: %v, leftHeight = %v, rightHeight = %v\n", h.k, h.v, leftHeight, rightHeight)
-		// 	return true
-		// }, 0)
-		return n
+}
+
+func (d *linearHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	buckets := d.buckets
+	valueIndex := -1
+	return &bucketIterator[K, V]{
+		length: len(buckets),
+		next: func(index int) *HashValue[K, V] {
+			valueIndex++
+			if valueIndex >= len(buckets[index]) {
+				valueIndex = -1
+				return nil
+			}
+			return buckets[index][valueIndex]
+		},
 	}
-	return nil
 }
 
-type rotateType int
+// ----------------------------------------------------------------
 
-const (
-	UNKNOWN rotateType = iota
-	LL
-	LR
-	RL
-	RR
-)
+type HashMap[K comparable, V any] struct {
+	loadFactor    float64           // allocator
+	useCount      uint              // allocator
+	minSize       uint              // allocator, never shrink below
+	initSize      uint              // allocator, size set by WithHashMapSize, 0 keeps the size of data
+	evacuateCount int               // allocator, hash indexes evacuated per operation, 0 means rehash at once
+	evacuateIndex int               // allocator, next hash index of oldData to evacuate
+	data          HashMapData[K, V] // data structure
+	oldData       HashMapData[K, V] // data structure being evacuated into data
+	hasher        Hasher[K]
+}
 
-func (n *avltNode) getRotateType() rotateType {
-	factor := n.getBalanceFactor()
-	if n.leftChild != nil {
-		if factor > 1 && n.leftChild.getBalanceFactor() >= 0 {
-			return LL
-		}
-		if factor > 1 && n.leftChild.getBalanceFactor() < 0 {
-			return LR
+func (h *HashMap[K, V]) Set(k K, v V) bool {
+	h.evacuate(h.evacuateCount)
+	_, exists := h.get(k)
+	if !exists && !h.selfResizing() {
+		if h.GetLoadFactor(1) > h.loadFactor {
+			h.resize(uint(h.data.Len()) << 1)
+		} else if h.GetLoadFactor(1+h.tombstones()) > h.loadFactor {
+			// most of the load is tombstones, rehash at the same size to clear them
+			h.resize(uint(h.data.Len()))
 		}
 	}
-	if n.rightChild != nil {
-		if factor < -1 && n.rightChild.getBalanceFactor() <= 0 {
-			return RR
+	hashValue := &HashValue[K, V]{
+		k: k,
+		v: v,
+	}
+	if !h.place(hashValue) {
+		// data structure is full around hash index, grow and try again
+		h.reallocate(uint(h.data.Len()) << 1)
+		if !h.place(hashValue) {
+			return false
 		}
-		if factor < -1 && n.rightChild.getBalanceFactor() > 0 {
-			return RL
+	}
+	if exists && h.oldData != nil {
+		// keep every key in exactly one data structure while evacuating
+		if hashIndex, ok := h.index(h.oldData, k); ok {
+			h.oldData.Del(hashIndex, k)
 		}
 	}
-	return UNKNOWN
+	if !exists {
+		h.useCount++
+	}
+	return true
 }
 
-func (n *avltNode) getRotateTypeByTargetNode(childNode *avltNode) rotateType {
-	if n.leftChild != nil {
-		if n.leftChild.leftChild != nil {
-			if n.leftChild.leftChild == childNode || n.leftChild.leftChild.leftChild == childNode || n.leftChild.leftChild.rightChild == childNode {
-				return LL
-			}
-		}
-		if n.leftChild.rightChild != nil {
-			if n.leftChild.rightChild == childNode || n.leftChild.rightChild.leftChild == childNode || n.leftChild.rightChild.rightChild == childNode {
-				return LR
-			}
+func (h *HashMap[K, V]) Get(k K) (V, bool) {
+	h.evacuate(h.evacuateCount)
+	return h.get(k)
+}
+
+func (h *HashMap[K, V]) Del(k K) (V, bool) {
+	h.evacuate(h.evacuateCount)
+	v, ok := *new(V), false
+	if hashIndex, inRange := h.index(h.data, k); inRange {
+		v, ok = h.data.Del(hashIndex, k)
+	}
+	if !ok && h.oldData != nil {
+		if hashIndex, inRange := h.index(h.oldData, k); inRange {
+			v, ok = h.oldData.Del(hashIndex, k)
 		}
 	}
-	if n.rightChild != nil {
-		if n.rightChild.rightChild != nil {
-			if n.rightChild.rightChild == childNode || n.rightChild.rightChild.leftChild == childNode || n.rightChild.rightChild.rightChild == childNode {
-				return RR
-			}
+	if !ok {
+		return *new(V), false
+	}
+	h.useCount--
+	if !h.selfResizing() && uint(h.data.Len()) > h.minSize && h.GetLoadFactor(0) < h.loadFactor*DEFAULT_SHRINK_RATIO {
+		h.resize(uint(h.data.Len()) >> 1)
+	}
+	return v, true
+}
+
+func (h *HashMap[K, V]) tombstones() uint {
+	if counter, ok := h.data.(tombstoneCounter); ok {
+		return uint(counter.Tombstones())
+	}
+	return 0
+}
+
+func (h *HashMap[K, V]) selfResizing() bool {
+	_, ok := h.data.(selfResizer)
+	return ok
+}
+
+func (h *HashMap[K, V]) index(data HashMapData[K, V], k K) (int, bool) {
+	hashIndex := h.hasher.Hash(k, uint(data.Len()))
+	return hashIndex, 0 <= hashIndex && hashIndex < data.Len()
+}
+
+func (h *HashMap[K, V]) get(k K) (V, bool) {
+	if hashIndex, ok := h.index(h.data, k); ok {
+		if v, ok := h.data.Get(hashIndex, k); ok {
+			return v, true
 		}
-		if n.rightChild.leftChild != nil {
-			if n.rightChild.leftChild == childNode || n.rightChild.leftChild.leftChild == childNode || n.rightChild.leftChild.rightChild == childNode {
-				return RL
-			}
+	}
+	if h.oldData != nil {
+		if hashIndex, ok := h.index(h.oldData, k); ok {
+			return h.oldData.Get(hashIndex, k)
 		}
 	}
-	return UNKNOWN
+	return *new(V), false
 }
 
-func (n *avltNode) setLeftChild(childNode *avltNode) {
-	n.leftChild = childNode
-	if childNode != nil {
-		childNode.parentNode = n
-		n.leftHeight = childNode.getHeight() + 1
-	} else {
-		n.leftHeight = 0
+func (h *HashMap[K, V]) place(hashValue *HashValue[K, V]) bool {
+	hashIndex, ok := h.index(h.data, hashValue.k)
+	return ok && h.data.Set(hashIndex, hashValue)
+}
+
+// resize reallocates data to the given size at once, or starts evacuating into a new data structure
+func (h *HashMap[K, V]) resize(size uint) {
+	if size == 0 {
+		return
 	}
+	if h.evacuateCount == 0 {
+		h.reallocate(size)
+		return
+	}
+	// finish the previous evacuation before starting a new one
+	if h.oldData != nil {
+		h.evacuate(h.oldData.Len() - h.evacuateIndex)
+	}
+	h.oldData, h.data = h.data, h.data.Allocate(size)
 }
 
-func (n *avltNode) setRightChild(childNode *avltNode) {
-	n.rightChild = childNode
-	if childNode != nil {
-		childNode.parentNode = n
-		n.rightHeight = childNode.getHeight() + 1
-	} else {
-		n.rightHeight = 0
+// reallocate keeps growing if any value can not be placed
+func (h *HashMap[K, V]) reallocate(size uint) {
+	for !h.data.Reallocate(size, h.hasher) {
+		size <<= 1
 	}
 }
 
-func (n *avltNode) getHeight() int {
-	if n.leftHeight < n.rightHeight {
-		return n.rightHeight
+// evacuate moves at most count hash indexes of oldData into data, like the runtime map growWork
+func (h *HashMap[K, V]) evacuate(count int) {
+	for ; count > 0 && h.oldData != nil; count-- {
+		h.oldData.Evacuate(h.evacuateIndex, func(hashValue *HashValue[K, V]) {
+			// the value is already out of oldData, keep growing data until it fits
+			for !h.place(hashValue) {
+				h.reallocate(uint(h.data.Len()) << 1)
+			}
+		})
+		h.evacuateIndex++
+		if h.evacuateIndex == h.oldData.Len() {
+			h.oldData, h.evacuateIndex = nil, 0
+		}
 	}
-	return n.leftHeight
 }
 
-func (n *avltNode) getBalanceFactor() int {
-	return n.leftHeight - n.rightHeight
+func (h *HashMap[K, V]) GetLoadFactor(delta uint) float64 {
+	return float64(h.useCount+delta) / float64(h.data.Len())
 }
 
-func (n *avltNode) leftRotate() *avltNode {
-	newRootNode := n.rightChild
-	if newRootNode != nil {
-		n.setRightChild(newRootNode.leftChild)
-		newRootNode.setLeftChild(n)
-	} else {
-		n.setRightChild(nil)
+func (h *HashMap[K, V]) Range(op func(k K, v V) bool) {
+	next := true
+	if h.oldData != nil {
+		h.oldData.Range(func(hashValue *HashValue[K, V]) bool {
+			next = op(hashValue.k, hashValue.v)
+			return next
+		})
+	}
+	if next {
+		h.data.Range(func(hashValue *HashValue[K, V]) bool {
+			return op(hashValue.k, hashValue.v)
+		})
 	}
-	return newRootNode
 }
 
-func (n *avltNode) rightRotate() *avltNode {
-	newRootNode := n.leftChild
-	if newRootNode != nil {
-		n.setLeftChild(newRootNode.rightChild)
-		newRootNode.setRightChild(n)
-	} else {
-		n.setLeftChild(nil)
+// HashMapIterator walks a HashMap like Range but lets the caller decide when to step.
+//
+// It reads the live data structures lazily, nothing is copied when it is made. Set and Del during the iteration
+// never make Next panic, but the iteration may then skip or repeat keys: a resize, an evacuation step of Set,
+// Get or Del, a rotation of a tree bucket or a backward shift of an open address backend may move values
+// behind or ahead of the iterator. Without any change every key is visited exactly once,
+// iterate over a Snapshot for a stable view while the HashMap keeps changing
+type HashMapIterator[K comparable, V any] struct {
+	iterator HashMapDataIterator[K, V]
+	key      K
+	value    V
+}
+
+func (h *HashMap[K, V]) Iterator() *HashMapIterator[K, V] {
+	iterators := make([]HashMapDataIterator[K, V], 0, 2)
+	if h.oldData != nil {
+		iterators = append(iterators, h.oldData.Iterator())
+	}
+	iterators = append(iterators, h.data.Iterator())
+	return &HashMapIterator[K, V]{
+		iterator: &chainIterator[K, V]{
+			iterators: iterators,
+		},
 	}
-	return newRootNode
 }
 
-type avltHashMapData struct {
-	buckets []*avltNode
+// Next moves to the next key and returns false after the last one, it must be called before the first Key and Value
+func (i *HashMapIterator[K, V]) Next() bool {
+	hashValue := i.iterator.Next()
+	if hashValue == nil {
+		i.key, i.value = *new(K), *new(V)
+		return false
+	}
+	i.key, i.value = hashValue.k, hashValue.v
+	return true
 }
 
-func (d *avltHashMapData) Len() int {
-	return len(d.buckets)
+func (i *HashMapIterator[K, V]) Key() K {
+	return i.key
 }
 
-func (d *avltHashMapData) Get(hashIndex, key int) (int, bool) {
-	if d.buckets[hashIndex] == nil {
-		return 0, false
-	} else {
-		node := d.buckets[hashIndex]
-		for {
-			if key < node.value.k {
-				if node.leftChild == nil {
-					return 0, false
-				} else {
-					node = node.leftChild
-				}
-			} else if node.value.k < key {
-				if node.rightChild == nil {
-					return 0, false
-				} else {
-					node = node.rightChild
-				}
-			} else {
-				return node.value.v, true
-			}
+func (i *HashMapIterator[K, V]) Value() V {
+	return i.value
+}
+
+// HashMapSnapshot is a read only view of a HashMap at the moment of Snapshot
+type HashMapSnapshot[K comparable, V any] struct {
+	hashMap *HashMap[K, V]
+}
+
+// Snapshot is cheap for the backends implementing snapshotter (dll and the tree backends except stHashMapData),
+// every other backend is copied as a whole. The origin HashMap keeps accepting Set and Del.
+// A snapshot of stHashMapData still splays its buckets on Get, so Get on one such snapshot is not safe for concurrent use
+func (h *HashMap[K, V]) Snapshot() *HashMapSnapshot[K, V] {
+	snapshot := &HashMap[K, V]{
+		loadFactor: h.loadFactor,
+		useCount:   h.useCount,
+		data:       h.snapshotData(h.data),
+		hasher:     h.hasher,
+	}
+	if h.oldData != nil {
+		snapshot.oldData = h.snapshotData(h.oldData)
+	}
+	return &HashMapSnapshot[K, V]{
+		hashMap: snapshot,
+	}
+}
+
+func (h *HashMap[K, V]) snapshotData(data HashMapData[K, V]) HashMapData[K, V] {
+	if s, ok := data.(snapshotter[K, V]); ok {
+		return s.Snapshot()
+	}
+	for size := uint(data.Len()); ; size <<= 1 {
+		copyData := data.Allocate(size)
+		if reallocate[K, V](data.Range, copyData, h.hasher) {
+			return copyData
 		}
 	}
 }
 
-func (d *avltHashMapData) Set(hashIndex int, hashValue *HashValue) bool {
-	vNode := &avltNode{
-		value: hashValue,
+func (s *HashMapSnapshot[K, V]) Len() int {
+	return int(s.hashMap.useCount)
+}
+
+func (s *HashMapSnapshot[K, V]) Get(k K) (V, bool) {
+	return s.hashMap.get(k)
+}
+
+func (s *HashMapSnapshot[K, V]) Range(op func(k K, v V) bool) {
+	s.hashMap.Range(op)
+}
+
+func (s *HashMapSnapshot[K, V]) Iterator() *HashMapIterator[K, V] {
+	return s.hashMap.Iterator()
+}
+
+type HashMapOption[K comparable, V any] func(*HashMap[K, V])
+
+func MakeHashMap[K comparable, V any](options ...HashMapOption[K, V]) *HashMap[K, V] {
+	hashMap := &HashMap[K, V]{
+		loadFactor: DEFAULT_LOAD_FACTOR,
+		data: &ldhHashMapData[K, V]{
+			array: make([]*HashValue[K, V], DEFAULT_HASH_MAP_SIZE),
+		},
+		hasher: defaultHasher[K]{},
 	}
-	if d.buckets[hashIndex] == nil {
-		d.buckets[hashIndex] = vNode
-	} else {
-		node := d.buckets[hashIndex]
-		for {
-			if hashValue.k < node.value.k {
-				if node.leftChild == nil {
-					vNode.parentNode = node
-					node.leftChild = vNode
-					break
-				} else {
-					node = node.leftChild
-				}
-			} else if node.value.k < hashValue.k {
-				if node.rightChild == nil {
-					vNode.parentNode = node
-					node.rightChild = vNode
-					break
-				} else {
-					node = node.rightChild
-				}
-			} else {
-				node.value = hashValue
-				return true
-			}
+	for _, option := range options {
+		option(hashMap)
+	}
+	// size after every option, so the final data and hasher are used whatever the option order is
+	if hashMap.initSize != 0 {
+		// defaultHasher masks with size-1, which reaches every index only for a power of two
+		size := uint(1) << bits.Len(hashMap.initSize-1)
+		if !hashMap.data.Reallocate(size, hashMap.hasher) {
+			panic(fmt.Sprintf("MakeHashMap reallocate %T to size %v failed\n", hashMap.data, size))
 		}
+	} else if hashMap.selfResizing() {
+		// dynamic hashing addresses keys by the hasher itself, reallocating hands the final hasher over
+		hashMap.data.Reallocate(uint(hashMap.data.Len()), hashMap.hasher)
+	}
+	hashMap.minSize = uint(hashMap.data.Len())
+	if resizer, ok := hashMap.data.(selfResizer); ok {
+		resizer.SetLoadFactor(hashMap.loadFactor)
+	}
+	return hashMap
+}
+
+func WithHashMapLoadFactor[K comparable, V any](factor float64) HashMapOption[K, V] {
+	return func(h *HashMap[K, V]) {
+		h.loadFactor = factor
 	}
+}
 
-	// balance
-	// fmt.Println()
-	// fmt.Printf("root node %v rebalance\n", d.buckets[hashIndex].value.k)
-	// d.buckets[hashIndex].rebalance() // 自根节点向下再平衡
-	// d.buckets[hashIndex].preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-	// 	fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-	// 	return true
-	// }, 0)
-
-	// fmt.Println()
-	// fmt.Printf("root node %v checkBalance\n", d.buckets[hashIndex].value.k)
-	// lostBalanceNode := d.buckets[hashIndex].checkBalance() // 自根节点向下检查平衡
-	// if lostBalanceNode != nil {
-	// 	lostBalanceNode.preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-	// 		fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-	// 		return true
-	// 	}, 0)
-	// }
+func WithHashMapData[K comparable, V any](data HashMapData[K, V]) HashMapOption[K, V] {
+	return func(h *HashMap[K, V]) {
+		h.data = data
+	}
+}
 
-	// fmt.Println()
-	// fmt.Printf("vNode %v checkAndRebalance(1)\n", vNode.value.k)
-	lostBalanceNode := vNode.checkAndRebalance(1) // 自插入节点向上检查平衡并且再平衡
-	// if lostBalanceNode != nil {
-	// 	lostBalanceNode.preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-	// 		fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-	// 		return true
-	// 	}, 0)
-	// }
-	// if lostBalanceNode != lostBalanceNode {
-	// 	panic(fmt.Sprintf("lostBalanceNode %v != lostBalanceNode %v, root node %v, vNode %v\n", lostBalanceNode.value.k, lostBalanceNode.value.k, d.buckets[hashIndex].value.k, vNode.value.k))
-	// }
+// WithHashMapSize reallocates data to size rounded up to a power of two after all options are applied,
+// MakeHashMap panics if it fails
+func WithHashMapSize[K comparable, V any](size uint) HashMapOption[K, V] {
+	return func(h *HashMap[K, V]) {
+		h.initSize = size
+	}
+}
 
-	if lostBalanceNode != nil {
-		lostBalanceNodeParent := lostBalanceNode.parentNode
-		var newRootNode *avltNode
-		rotateType := lostBalanceNode.getRotateType()
-		// rotateTypeByTargetNode := lostBalanceNode.getRotateTypeByTargetNode(vNode)
-		// if rotateType != rotateTypeByTargetNode {
-		// 	panic(fmt.Sprintf("lostBalanceNode %v getRotateType() %v != getRotateTypeByTargetNode(%v) %v", lostBalanceNode.value.k, vNode.value.k, rotateType, rotateTypeByTargetNode))
-		// }
-		// fmt.Printf("avl-tree need change to keep balance, rotate type %v\n", rotateType)
-		switch rotateType {
-		case LR:
-			lostBalanceNode.setLeftChild(lostBalanceNode.leftChild.leftRotate())
-			fallthrough
-		case LL:
-			newRootNode = lostBalanceNode.rightRotate()
-		case RL:
-			lostBalanceNode.setRightChild(lostBalanceNode.rightChild.rightRotate())
-			fallthrough
-		case RR:
-			newRootNode = lostBalanceNode.leftRotate()
-		default:
-			fmt.Printf("Error: lost balance node %v rotate type wrong\n", lostBalanceNode)
-			lostBalanceNode.preOrderTraversal(func(h *HashValue) bool {
-				fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
-				return true
-			}, 0)
-			panic(fmt.Sprintf("Error: lost balance node %v rotate type wrong\n", lostBalanceNode))
+// WithHashMapIncrementalRehash evacuates count hash indexes per Set/Get/Del instead of rehashing at once
+func WithHashMapIncrementalRehash[K comparable, V any](count int) HashMapOption[K, V] {
+	return func(h *HashMap[K, V]) {
+		if count <= 0 {
+			count = DEFAULT_EVACUATE_COUNT
 		}
+		h.evacuateCount = count
+	}
+}
 
-		if lostBalanceNodeParent == nil {
-			d.buckets[hashIndex] = newRootNode
-			d.buckets[hashIndex].parentNode = nil
-		} else {
-			if lostBalanceNodeParent.leftChild == lostBalanceNode {
-				lostBalanceNodeParent.setLeftChild(newRootNode)
-			} else if lostBalanceNodeParent.rightChild == lostBalanceNode {
-				lostBalanceNodeParent.setRightChild(newRootNode)
-			} else {
-				fmt.Printf("Error: lost balance node %v is not exists in its parent %v child\n", lostBalanceNode.value.k, lostBalanceNodeParent.value.k)
-				panic(fmt.Sprintf("Error: lost balance node %v is not exists in its parent %v child\n", lostBalanceNode.value.k, lostBalanceNodeParent.value.k))
-			}
-		}
+func WithHashMapHasher[K comparable, V any](hasher Hasher[K]) HashMapOption[K, V] {
+	return func(h *HashMap[K, V]) {
+		h.hasher = hasher
 	}
+}
 
-	// d.buckets[hashIndex].preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-	// 	fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-	// 	return true
-	// }, 0)
+func WithHashMapHashFunc[K comparable, V any](f func(K, uint) int) HashMapOption[K, V] {
+	return func(h *HashMap[K, V]) {
+		h.hasher = HasherFunc[K](f)
+	}
+}
 
-	return true
+// ----------------------------------------------------------------
+
+// frozen hash map, minimal perfect hashing by CHD (compress, hash and displace)
+
+// keys are grouped into buckets by one hash, then every bucket from the largest one searches a displacement
+// which places all of its keys into free slots, so n keys take exactly n slots without any collision
+
+const (
+	FROZEN_BUCKET_LOAD       = 4       // average keys per bucket
+	FROZEN_MAX_DISPLACEMENT  = 1 << 24 // try another seed if a bucket finds no displacement below it
+	FROZEN_MAX_SEED          = 64      // give up after so many seeds, Freeze rejects keys sharing the same hash64 before
+	frozenDisplacementFactor = 0x9e3779b97f4a7c15
+)
+
+type FrozenHashMap[K comparable, V any] struct {
+	seed          uint64
+	displacements []uint32 // displacement of every bucket
+	keys          []K      // keys[position] is the only key hashed to position
+	values        []V
 }
 
-// type 1 delete leaf
-//   7              7
-//  5 8  -> Del(1) 5 8
-// 1 6 9            6 9
-// type 3 delete node only has right tree
-//   7               7
-//  5 8  -> Del(8)  5 9
-// 1 6 9           1 6
-// type 4 delete node has both left and right tree
-//   7               7
-//  5 8  -> Del(5)  6 8
-// 1 6 9           1   9
-func (d *avltHashMapData) Del(hashIndex, key int) (int, bool) {
-	if d.buckets[hashIndex] == nil {
-		return 0, true
-	} else {
-		// fmt.Println()
-		// fmt.Printf("Before Delete %v preOrder\n", key)
-		// d.buckets[hashIndex].preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-		// 	fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-		// 	return true
-		// }, 0)
-		var parentNode *avltNode
-		node := d.buckets[hashIndex]
-		for {
-			if key < node.value.k {
-				if node.leftChild == nil {
-					return 0, true
-				} else {
-					parentNode = node
-					node = node.leftChild
-				}
-			} else if node.value.k < key {
-				if node.rightChild == nil {
-					return 0, true
-				} else {
-					parentNode = node
-					node = node.rightChild
-				}
-			} else {
-				value, deleteNode := node.value.v, node
-				var newNode *avltNode
-				leftChild := node.leftChild
-				rightChild := node.rightChild
-				minRightNodeParentNode := node
-				for node = node.rightChild; node != nil && node.leftChild != nil; minRightNodeParentNode, node = node, node.leftChild {
-				}
-				if node == nil { // 单左链表
-					newNode = leftChild
-				} else if minRightNodeParentNode == deleteNode { // 单右链表
-					newNode = deleteNode.rightChild
-					newNode.setLeftChild(leftChild)
-				} else if minRightNodeParentNode != node {
-					minRightNodeParentNode.setLeftChild(node.rightChild)
-					node.setLeftChild(leftChild)
-					node.setRightChild(rightChild)
-					newNode = node
-				} else {
-					newNode = node.rightChild
-				}
+// frozenImage is the serialized form of FrozenHashMap, gob only encodes exported fields
+type frozenImage[K comparable, V any] struct {
+	Seed          uint64
+	Displacements []uint32
+	Keys          []K
+	Values        []V
+}
 
-				var checkNode *avltNode
-				if parentNode == nil {
-					d.buckets[hashIndex] = newNode
-					if newNode == nil {
-						return value, true
-					}
-					newNode.parentNode = nil
-					checkNode = d.buckets[hashIndex]
-				} else if parentNode.leftChild == deleteNode {
-					parentNode.setLeftChild(newNode)
-					checkNode = parentNode
-				} else if parentNode.rightChild == deleteNode {
-					parentNode.setRightChild(newNode)
-					checkNode = parentNode
-				} else {
-					panic(fmt.Sprintf("new node %v does has parent node %v but parent node not has new node\n", newNode.value.k, parentNode.value.k))
-				}
+func (f *FrozenHashMap[K, V]) hash(key K) uint64 {
+	return mix64(hash64(key) ^ f.seed)
+}
 
-				deleteNode.leftChild = nil
-				deleteNode.rightChild = nil
+func (f *FrozenHashMap[K, V]) position(h uint64, displacement uint32) int {
+	return int(mix64(h^(uint64(displacement)+1)*frozenDisplacementFactor) % uint64(len(f.keys)))
+}
 
-				if d.buckets[hashIndex] != nil {
-					fmt.Printf("After Delete %v preOrder\n", key)
-					d.buckets[hashIndex].preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-						fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-						return true
-					}, 0)
-				}
+// Freeze builds an immutable FrozenHashMap over the current keys, later changes of h do not affect it.
+// The frozen map hashes keys by hash64, the hasher of h is not used.
+// It returns an error if two keys share the same hash64, no seed can tell them apart
+func (h *HashMap[K, V]) Freeze() (*FrozenHashMap[K, V], error) {
+	keys, values := make([]K, 0, h.useCount), make([]V, 0, h.useCount)
+	hashKeys := make(map[uint64]K, h.useCount)
+	var sameHashError error
+	h.Range(func(k K, v V) bool {
+		hashValue := hash64(k)
+		if other, hasHash := hashKeys[hashValue]; hasHash {
+			sameHashError = fmt.Errorf("frozen hash map: keys %#v and %#v share the same hash64 %#x", other, k, hashValue)
+			return false
+		}
+		hashKeys[hashValue] = k
+		keys, values = append(keys, k), append(values, v)
+		return true
+	})
+	if sameHashError != nil {
+		return nil, sameHashError
+	}
+	for seed := uint64(0); seed != FROZEN_MAX_SEED; seed++ {
+		if frozen, ok := buildFrozenHashMap(seed, keys, values); ok {
+			return frozen, nil
+		}
+	}
+	return nil, fmt.Errorf("frozen hash map: no displacement found for %v keys in %v seeds", len(keys), FROZEN_MAX_SEED)
+}
 
-				// balance
-				// fmt.Println()
-				// fmt.Printf("node %v rebalance\n", checkNode.value.k)
-				checkNode.rebalance() // 自变更节点向下再平衡
-				// checkNode.preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-				// 	fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-				// 	return true
-				// }, 0)
-
-				// fmt.Println()
-				// fmt.Printf("node %v checkBalance\n", checkNode.value.k)
-				lostBalanceNode := checkNode.checkBalance() // 自变更节点向下检查平衡
-				// if lostBalanceNode != nil {
-				// 	lostBalanceNode.preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-				// 		fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-				// 		return true
-				// 	}, 0)
-				// }
+// frozenStableKey reports whether hash64 of the key type is the same in every process,
+// pointers and channels are hashed by address and interfaces may hold them
+func frozenStableKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer, reflect.Interface:
+		return false
+	case reflect.Array:
+		return frozenStableKey(t.Elem())
+	case reflect.Struct:
+		for index := 0; index != t.NumField(); index++ {
+			if !frozenStableKey(t.Field(index).Type) {
+				return false
+			}
+		}
+	}
+	return true
+}
 
-				if lostBalanceNode == nil {
-					// fmt.Println()
-					// fmt.Printf("node %v checkAndRebalance(1)\n", vNode.value.k)
-					lostBalanceNode = checkNode.checkAndRebalance(checkNode.getHeight() + 1) // 自变更节点向上检查平衡并且再平衡
-					// if lostBalanceNode != nil {
-					// 	lostBalanceNode.preOrderTraversalWithHeight(func(h *HashValue, leftHeight, rightHeight int) bool {
-					// 		fmt.Printf("DEBUG: range key: %v, value: %v, left height: %v, right height: %v\n", h.k, h.v, leftHeight, rightHeight)
-					// 		return true
-					// 	}, 0)
-					// }
-					// if lostBalanceNode != lostBalanceNode {
-					// 	panic(fmt.Sprintf("lostBalanceNode %v != lostBalanceNode %v, root node %v, vNode %v\n", lostBalanceNode.value.k, lostBalanceNode.value.k, d.buckets[hashIndex].value.k, vNode.value.k))
-					// }
-				}
+func frozenCheckKey[K comparable]() error {
+	if keyType := reflect.TypeOf((*K)(nil)).Elem(); !frozenStableKey(keyType) {
+		return fmt.Errorf("frozen hash map: hash of key type %v is not stable across processes", keyType)
+	}
+	return nil
+}
 
-				if lostBalanceNode != nil {
-					lostBalanceNodeParent := lostBalanceNode.parentNode
-					var newRootNode *avltNode
-					rotateType := lostBalanceNode.getRotateType()
-					// fmt.Printf("avl-tree need change to keep balance, rotate type %v\n", rotateType)
-					switch rotateType {
-					case LR:
-						lostBalanceNode.setLeftChild(lostBalanceNode.leftChild.leftRotate())
-						fallthrough
-					case LL:
-						newRootNode = lostBalanceNode.rightRotate()
-					case RL:
-						lostBalanceNode.setRightChild(lostBalanceNode.rightChild.rightRotate())
-						fallthrough
-					case RR:
-						newRootNode = lostBalanceNode.leftRotate()
-					default:
-						fmt.Printf("Error: lost balance node %v rotate type wrong\n", lostBalanceNode)
-						lostBalanceNode.preOrderTraversal(func(h *HashValue) bool {
-							fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
-							return true
-						}, 0)
-						panic(fmt.Sprintf("Error: lost balance node %v rotate type wrong\n", lostBalanceNode))
-					}
+func buildFrozenHashMap[K comparable, V any](seed uint64, keys []K, values []V) (*FrozenHashMap[K, V], bool) {
+	frozen := &FrozenHashMap[K, V]{
+		seed:          seed,
+		displacements: make([]uint32, (len(keys)+FROZEN_BUCKET_LOAD-1)/FROZEN_BUCKET_LOAD),
+		keys:          make([]K, len(keys)),
+		values:        make([]V, len(values)),
+	}
+	if len(keys) == 0 {
+		return frozen, true
+	}
+	hashes := make([]uint64, len(keys))
+	buckets := make([][]int, len(frozen.displacements))
+	for index, key := range keys {
+		hashes[index] = frozen.hash(key)
+		bucketIndex := hashes[index] % uint64(len(buckets))
+		buckets[bucketIndex] = append(buckets[bucketIndex], index)
+	}
+	bucketOrder := make([]int, len(buckets))
+	for index := range bucketOrder {
+		bucketOrder[index] = index
+	}
+	sort.SliceStable(bucketOrder, func(i, j int) bool {
+		return len(buckets[bucketOrder[i]]) > len(buckets[bucketOrder[j]])
+	})
 
-					if lostBalanceNodeParent == nil {
-						d.buckets[hashIndex] = newRootNode
-						d.buckets[hashIndex].parentNode = nil
-					} else {
-						if lostBalanceNodeParent.leftChild == lostBalanceNode {
-							lostBalanceNodeParent.setLeftChild(newRootNode)
-						} else if lostBalanceNodeParent.rightChild == lostBalanceNode {
-							lostBalanceNodeParent.setRightChild(newRootNode)
-						} else {
-							fmt.Printf("Error: lost balance node %v is not exists in its parent %v child\n", lostBalanceNode.value.k, parentNode.value.k)
-							panic(fmt.Sprintf("Error: lost balance node %v is not exists in its parent %v child\n", lostBalanceNode.value.k, parentNode.value.k))
-						}
+	occupied := make([]bool, len(keys))
+	positions := make([]int, 0, FROZEN_BUCKET_LOAD)
+	for _, bucketIndex := range bucketOrder {
+		bucket := buckets[bucketIndex]
+		if len(bucket) == 0 {
+			break
+		}
+		displacement := uint32(0)
+	DISPLACE:
+		for ; displacement != FROZEN_MAX_DISPLACEMENT; displacement++ {
+			// 同一个桶内的键也不能互相冲突，失败时撤销本次占用
+			positions = positions[:0]
+			for _, keyIndex := range bucket {
+				position := frozen.position(hashes[keyIndex], displacement)
+				if occupied[position] {
+					for _, placed := range positions {
+						occupied[placed] = false
 					}
+					continue DISPLACE
 				}
-				return value, true
+				occupied[position] = true
+				positions = append(positions, position)
 			}
+			break
+		}
+		if displacement == FROZEN_MAX_DISPLACEMENT {
+			return nil, false
+		}
+		frozen.displacements[bucketIndex] = displacement
+		for index, keyIndex := range bucket {
+			frozen.keys[positions[index]], frozen.values[positions[index]] = keys[keyIndex], values[keyIndex]
 		}
 	}
+	return frozen, true
 }
 
-func (d *avltHashMapData) Range(op func(*HashValue) bool) {
-	for _, bucket := range d.buckets {
-		if bucket != nil {
-			// fmt.Println()
-			// fmt.Printf("inOrderTraversal\n")
-			// if !bucket.inOrderTraversal(op) {
-			// 	return
-			// }
-			fmt.Println()
-			fmt.Printf("preOrderTraversal\n")
-			if !bucket.preOrderTraversal(op, 0) {
-				return
-			}
+func (f *FrozenHashMap[K, V]) Len() int {
+	return len(f.keys)
+}
+
+func (f *FrozenHashMap[K, V]) Get(k K) (V, bool) {
+	if len(f.keys) == 0 {
+		return *new(V), false
+	}
+	h := f.hash(k)
+	position := f.position(h, f.displacements[h%uint64(len(f.displacements))])
+	if f.keys[position] != k {
+		return *new(V), false
+	}
+	return f.values[position], true
+}
+
+func (f *FrozenHashMap[K, V]) Range(op func(k K, v V) bool) {
+	for position, key := range f.keys {
+		if !op(key, f.values[position]) {
+			return
 		}
 	}
 }
 
-func (d *avltHashMapData) Reallocate(size uint) {
-	if uint(len(d.buckets)) != size {
-		d.buckets = make([]*avltNode, size)
+// MarshalBinary serializes the frozen map by gob, K and V must be gob encodable,
+// and K must not be or contain pointers, channels or interfaces whose hash64 differs after loading
+func (f *FrozenHashMap[K, V]) MarshalBinary() ([]byte, error) {
+	if err := frozenCheckKey[K](); err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(frozenImage[K, V]{
+		Seed:          f.seed,
+		Displacements: f.displacements,
+		Keys:          f.keys,
+		Values:        f.values,
+	}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalBinary loads the frozen map serialized by MarshalBinary
+func (f *FrozenHashMap[K, V]) UnmarshalBinary(data []byte) error {
+	if err := frozenCheckKey[K](); err != nil {
+		return err
+	}
+	var image frozenImage[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&image); err != nil {
+		return err
+	}
+	if len(image.Keys) != len(image.Values) || len(image.Displacements) != (len(image.Keys)+FROZEN_BUCKET_LOAD-1)/FROZEN_BUCKET_LOAD {
+		return fmt.Errorf("frozen hash map: %v keys, %v values and %v displacements not match", len(image.Keys), len(image.Values), len(image.Displacements))
+	}
+	f.seed, f.displacements, f.keys, f.values = image.Seed, image.Displacements, image.Keys, image.Values
+	return nil
+}
+
+// LoadFrozenHashMap is a shortcut of UnmarshalBinary on a new FrozenHashMap
+func LoadFrozenHashMap[K comparable, V any](data []byte) (*FrozenHashMap[K, V], error) {
+	frozen := &FrozenHashMap[K, V]{}
+	if err := frozen.UnmarshalBinary(data); err != nil {
+		return nil, err
 	}
-	// TODO: move data
+	return frozen, nil
 }
 
 // ----------------------------------------------------------------
 
-// 2-3 tree - TTT
+// concurrent hash map, keys are split across independent HashMap shards by the high bits of hash
 
-type tttNode struct {
-	leftValue, middleValue, rightValue            *HashValue // middleValue 辅助数据
-	leftChild, middleChild, rightChild            *tttNode   // 子树
-	parentNode, middleLeftChild, middleRightChild *tttNode   // 辅助树
+const DEFAULT_CONCURRENT_SHARD_COUNT = 32
+
+type concurrentShard[K comparable, V any] struct {
+	sync.RWMutex
+	hashMap *HashMap[K, V]
 }
 
-func (n *tttNode) preOrderTraversal(op func(*HashValue) bool, deep int) bool {
-	if n.leftChild != nil && !n.leftChild.preOrderTraversal(op, deep+1) {
-		return false
-	}
-	if n.leftValue != nil {
-		fmt.Printf("%v", strings.Repeat("\t", deep))
-		if !op(n.leftValue) {
-			return false
-		}
+type ConcurrentHashMap[K comparable, V any] struct {
+	shards       []concurrentShard[K, V]
+	shardBits    uint // len(shards) == 1<<shardBits
+	exclusiveGet bool // Get holds the write lock, the data structure is a mutatingGetter
+}
+
+// MakeConcurrentHashMap rounds shardCount up to a power of two, DEFAULT_CONCURRENT_SHARD_COUNT if not positive.
+// Every shard is made by options and then allocates its own data structure of the same kind
+func MakeConcurrentHashMap[K comparable, V any](shardCount int, options ...HashMapOption[K, V]) *ConcurrentHashMap[K, V] {
+	if shardCount <= 0 {
+		shardCount = DEFAULT_CONCURRENT_SHARD_COUNT
 	}
-	if n.middleChild != nil && !n.middleChild.preOrderTraversal(op, deep+1) {
-		return false
+	shardBits := uint(bits.Len(uint(shardCount - 1)))
+	concurrentHashMap := &ConcurrentHashMap[K, V]{
+		shards:    make([]concurrentShard[K, V], 1<<shardBits),
+		shardBits: shardBits,
 	}
-	if n.rightValue != nil {
-		fmt.Printf("%v", strings.Repeat("\t", deep))
-		if !op(n.rightValue) {
-			return false
+	for index := range concurrentHashMap.shards {
+		hashMap := MakeHashMap(options...)
+		hashMap.data = hashMap.data.Allocate(uint(hashMap.data.Len()))
+		if resizer, ok := hashMap.data.(selfResizer); ok {
+			resizer.SetLoadFactor(hashMap.loadFactor)
 		}
+		concurrentHashMap.shards[index].hashMap = hashMap
 	}
-	if n.rightChild != nil && !n.rightChild.preOrderTraversal(op, deep+1) {
-		return false
+	_, concurrentHashMap.exclusiveGet = concurrentHashMap.shards[0].hashMap.data.(mutatingGetter)
+	return concurrentHashMap
+}
+
+// shard takes the high bits of the mixed hash, the low bits still address the hash index inside the shard
+func (c *ConcurrentHashMap[K, V]) shard(k K) *concurrentShard[K, V] {
+	if c.shardBits == 0 {
+		return &c.shards[0]
 	}
-	return true
+	return &c.shards[mix64(hash64(k))>>(64-c.shardBits)]
 }
 
-func (n *tttNode) getKeyString() string {
-	var keyString string
-	if n.leftValue != nil {
-		keyString = fmt.Sprintf("%v", n.leftValue.k)
+func (c *ConcurrentHashMap[K, V]) Set(k K, v V) bool {
+	shard := c.shard(k)
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.hashMap.Set(k, v)
+}
+
+// Get holds the read lock only, it does not evacuate like HashMap.Get and leaves the evacuation to Set and Del.
+// The write lock is held instead if Get changes the data structure (stHashMapData)
+func (c *ConcurrentHashMap[K, V]) Get(k K) (V, bool) {
+	shard := c.shard(k)
+	if c.exclusiveGet {
+		shard.Lock()
+		defer shard.Unlock()
+	} else {
+		shard.RLock()
+		defer shard.RUnlock()
 	}
-	if n.rightValue != nil {
-		if len(keyString) == 0 {
-			keyString = fmt.Sprintf("%v", n.rightValue.k)
-		} else {
-			keyString = fmt.Sprintf("%v,%v", keyString, n.rightValue.k)
+	return shard.hashMap.get(k)
+}
+
+func (c *ConcurrentHashMap[K, V]) Del(k K) (V, bool) {
+	shard := c.shard(k)
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.hashMap.Del(k)
+}
+
+func (c *ConcurrentHashMap[K, V]) Len() int {
+	count := 0
+	for index := range c.shards {
+		c.shards[index].RLock()
+		count += int(c.shards[index].hashMap.useCount)
+		c.shards[index].RUnlock()
+	}
+	return count
+}
+
+// Range visits shards one by one under the read lock of each, so every shard is seen consistently
+// but different shards may be seen at different moments. op must not change the map, or it deadlocks
+func (c *ConcurrentHashMap[K, V]) Range(op func(k K, v V) bool) {
+	for index := range c.shards {
+		next := true
+		c.shards[index].RLock()
+		c.shards[index].hashMap.Range(func(k K, v V) bool {
+			next = op(k, v)
+			return next
+		})
+		c.shards[index].RUnlock()
+		if !next {
+			return
 		}
 	}
-	return keyString
 }
 
-func (n *tttNode) validateCheck() {
-	if n.leftValue == nil && n.rightValue != nil {
-		panic(fmt.Sprintf("node %+v left value is nil but right value is not nil\n", n))
+// lock free read - LFR
+
+// chained buckets like dll, but a node never changes once published: writers copy the nodes before the changed one
+// and swap the bucket head atomically, readers load the bucket head without any lock and see a whole old or new chain
+
+type lockFreeNode[K comparable, V any] struct {
+	nextNode *lockFreeNode[K, V]
+	value    *HashValue[K, V]
+}
+
+func (n *lockFreeNode[K, V]) find(key K) *lockFreeNode[K, V] {
+	for ; n != nil; n = n.nextNode {
+		if n.value.k == key {
+			return n
+		}
 	}
-	if n.leftValue == nil && (n.leftChild != nil || n.middleChild != nil) {
-		panic(fmt.Sprintf("node %+v left value is nil but left or middle child is not nil\n", n))
+	return nil
+}
+
+// replace returns a new chain in which the node of key is replaced by op, nodes after it are shared with the origin chain
+func (n *lockFreeNode[K, V]) replace(key K, op func(*lockFreeNode[K, V]) *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+	if n.value.k == key {
+		return op(n)
 	}
-	if n.rightValue == nil && n.rightChild != nil {
-		panic(fmt.Sprintf("node %+v right value is nil but right child is not nil\n", n))
+	return &lockFreeNode[K, V]{
+		nextNode: n.nextNode.replace(key, op),
+		value:    n.value,
 	}
-	if n.leftChild != nil {
-		n.leftChild.validateCheck()
+}
+
+// set returns the new chain and whether key exists in the origin chain
+func (n *lockFreeNode[K, V]) set(hashValue *HashValue[K, V]) (*lockFreeNode[K, V], bool) {
+	if n.find(hashValue.k) == nil {
+		return &lockFreeNode[K, V]{
+			nextNode: n,
+			value:    hashValue,
+		}, false
 	}
-	if n.rightChild != nil {
-		n.rightChild.validateCheck()
+	return n.replace(hashValue.k, func(node *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+		return &lockFreeNode[K, V]{
+			nextNode: node.nextNode,
+			value:    hashValue,
+		}
+	}), true
+}
+
+// del returns the new chain and the deleted value, nil if key does not exist
+func (n *lockFreeNode[K, V]) del(key K) (*lockFreeNode[K, V], *HashValue[K, V]) {
+	node := n.find(key)
+	if node == nil {
+		return n, nil
 	}
+	return n.replace(key, func(node *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+		return node.nextNode
+	}), node.value
+}
+
+// lockFreeTable is never resized in place, a resize publishes a whole new table
+type lockFreeTable[K comparable, V any] struct {
+	buckets []atomic.Pointer[lockFreeNode[K, V]]
+	locks   []sync.Mutex // serializes the writers of every bucket
+}
+
+type lockFreeHashMapData[K comparable, V any] struct {
+	table  atomic.Pointer[lockFreeTable[K, V]]
+	resize sync.RWMutex // writers hold the read lock, Reallocate holds the write lock until the new table is published
+}
+
+func (d *lockFreeHashMapData[K, V]) allocate(size uint) *lockFreeHashMapData[K, V] {
+	newData := &lockFreeHashMapData[K, V]{}
+	newData.table.Store(&lockFreeTable[K, V]{
+		buckets: make([]atomic.Pointer[lockFreeNode[K, V]], size),
+		locks:   make([]sync.Mutex, size),
+	})
+	return newData
 }
 
-func (n *tttNode) resetNodeValue(leftValue, middleValue, rightValue *HashValue) {
-	n.leftValue, n.middleValue, n.rightValue = leftValue, middleValue, rightValue
+func (d *lockFreeHashMapData[K, V]) Len() int {
+	return len(d.table.Load().buckets)
 }
 
-func (n *tttNode) splitNode() *tttNode {
-	newLeftChild := &tttNode{
-		leftValue: n.leftValue,
+func (d *lockFreeHashMapData[K, V]) Get(hashIndex int, key K) (V, bool) {
+	if node := d.table.Load().buckets[hashIndex].Load().find(key); node != nil {
+		return node.value.v, true
 	}
-	if n.leftChild != nil {
-		newLeftChild.setLeftChild(n.leftChild)
+	return *new(V), false
+}
+
+// update locks the bucket chosen by index on the current table, and publishes the chain returned by op as its new head
+func (d *lockFreeHashMapData[K, V]) update(index func(*lockFreeTable[K, V]) int, op func(*lockFreeNode[K, V]) *lockFreeNode[K, V]) {
+	d.resize.RLock()
+	defer d.resize.RUnlock()
+	table := d.table.Load()
+	hashIndex := index(table)
+	table.locks[hashIndex].Lock()
+	defer table.locks[hashIndex].Unlock()
+	table.buckets[hashIndex].Store(op(table.buckets[hashIndex].Load()))
+}
+
+func (d *lockFreeHashMapData[K, V]) Set(hashIndex int, hashValue *HashValue[K, V]) bool {
+	d.update(func(*lockFreeTable[K, V]) int {
+		return hashIndex
+	}, func(head *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+		head, _ = head.set(hashValue)
+		return head
+	})
+	return true
+}
+
+func (d *lockFreeHashMapData[K, V]) Del(hashIndex int, key K) (V, bool) {
+	var hashValue *HashValue[K, V]
+	d.update(func(*lockFreeTable[K, V]) int {
+		return hashIndex
+	}, func(head *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+		head, hashValue = head.del(key)
+		return head
+	})
+	if hashValue == nil {
+		return *new(V), false
 	}
-	if n.middleLeftChild != nil {
-		newLeftChild.setMiddleChild(n.middleLeftChild)
+	return hashValue.v, true
+}
+
+// Range sees every bucket at the moment it is loaded, not a snapshot of the whole table
+func (d *lockFreeHashMapData[K, V]) Range(op func(*HashValue[K, V]) bool) {
+	table := d.table.Load()
+	for index := range table.buckets {
+		for node := table.buckets[index].Load(); node != nil; node = node.nextNode {
+			if !op(node.value) {
+				return
+			}
+		}
 	}
+}
+
+// Reallocate blocks writers while building the new table, readers keep reading the old one until it is published
+func (d *lockFreeHashMapData[K, V]) Reallocate(size uint, hasher Hasher[K]) bool {
+	d.resize.Lock()
+	defer d.resize.Unlock()
+	return d.reallocate(size, hasher)
+}
 
-	newMiddleChild := &tttNode{
-		leftValue: n.rightValue,
+func (d *lockFreeHashMapData[K, V]) reallocate(size uint, hasher Hasher[K]) bool {
+	if uint(d.Len()) == size {
+		return true
 	}
-	if n.middleRightChild != nil {
-		newMiddleChild.setLeftChild(n.middleRightChild)
+	newData := d.allocate(size)
+	newTable := newData.table.Load()
+	ok := true
+	d.Range(func(hashValue *HashValue[K, V]) bool {
+		hashIndex := hasher.Hash(hashValue.k, size)
+		if ok = 0 <= hashIndex && hashIndex < int(size); ok {
+			head, _ := newTable.buckets[hashIndex].Load().set(hashValue)
+			newTable.buckets[hashIndex].Store(head)
+		}
+		return ok
+	})
+	if ok {
+		d.table.Store(newTable)
 	}
-	if n.rightChild != nil {
-		newMiddleChild.setMiddleChild(n.rightChild)
+	return ok
+}
+
+func (d *lockFreeHashMapData[K, V]) Allocate(size uint) HashMapData[K, V] {
+	return d.allocate(size)
+}
+
+func (d *lockFreeHashMapData[K, V]) Evacuate(hashIndex int, op func(*HashValue[K, V])) {
+	var head *lockFreeNode[K, V]
+	d.update(func(*lockFreeTable[K, V]) int {
+		return hashIndex
+	}, func(node *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+		head = node
+		return nil
+	})
+	for ; head != nil; head = head.nextNode {
+		op(head.value)
 	}
+}
 
-	newRootNode := &tttNode{
-		leftValue: n.middleValue,
+// Iterator walks the table present at creation, and every bucket as it is when the iterator gets there
+func (d *lockFreeHashMapData[K, V]) Iterator() HashMapDataIterator[K, V] {
+	table := d.table.Load()
+	var node *lockFreeNode[K, V]
+	return &bucketIterator[K, V]{
+		length: len(table.buckets),
+		next: func(index int) *HashValue[K, V] {
+			if node == nil {
+				node = table.buckets[index].Load()
+			} else {
+				node = node.nextNode
+			}
+			if node == nil {
+				return nil
+			}
+			return node.value
+		},
 	}
-	newRootNode.setLeftChild(newLeftChild)
-	newRootNode.setMiddleChild(newMiddleChild)
+}
 
-	return newRootNode
+// LockFreeReadHashMap is a read mostly map on lockFreeHashMapData, Get takes no lock,
+// Set and Del on different buckets run in parallel, it grows by DEFAULT_LOAD_FACTOR and never shrinks
+type LockFreeReadHashMap[K comparable, V any] struct {
+	data     *lockFreeHashMapData[K, V]
+	hasher   Hasher[K]
+	useCount atomic.Int64
 }
 
-func (n *tttNode) newSplitNodeType1(insertHashValue *HashValue, insertType InsertType) *tttNode {
-	newLeftChild := &tttNode{}
-	if n.leftChild != nil {
-		newLeftChild.setLeftChild(n.leftChild)
+// MakeLockFreeReadHashMap uses defaultHasher if hasher is nil
+func MakeLockFreeReadHashMap[K comparable, V any](size uint, hasher Hasher[K]) *LockFreeReadHashMap[K, V] {
+	if size == 0 {
+		size = DEFAULT_HASH_MAP_SIZE
 	}
-
-	newMiddleChild := &tttNode{}
-	if n.rightChild != nil {
-		newMiddleChild.setMiddleChild(n.rightChild)
+	if hasher == nil {
+		hasher = defaultHasher[K]{}
 	}
-
-	newRootNode := &tttNode{}
-	newRootNode.setLeftChild(newLeftChild)
-	newRootNode.setMiddleChild(newMiddleChild)
-
-	switch insertType {
-	case InsertLeft:
-		newLeftChild.leftValue = insertHashValue
-		newRootNode.leftValue = n.leftValue
-		newMiddleChild.leftValue = n.rightValue
-	case InsertMiddle:
-		newLeftChild.leftValue = n.leftValue
-		newRootNode.leftValue = insertHashValue
-		newMiddleChild.leftValue = n.rightValue
-	case InsertRight:
-		newLeftChild.leftValue = n.leftValue
-		newRootNode.leftValue = n.rightValue
-		newMiddleChild.leftValue = insertHashValue
+	return &LockFreeReadHashMap[K, V]{
+		data:   (&lockFreeHashMapData[K, V]{}).allocate(size),
+		hasher: hasher,
 	}
-
-	return newRootNode
 }
 
-func (n *tttNode) newSplitNodeType2(originNode, newNode *tttNode) {
-	if n.leftChild == originNode {
-		//     D     C     C,D
-		//    /| +  /| =  / | \
-		// A,C E   A B   A  B  E
-		n.setRightChild(n.middleChild)
-		n.setMiddleChild(newNode.middleChild)
-		n.setLeftChild(newNode.leftChild)
-		n.resetNodeValue(newNode.leftValue, nil, n.leftValue)
-	} else if n.middleChild == originNode {
-		//   D       F     D,F
-		//  /|   +  /| =  / | \
-		// C E,G   E G   C  E  G
-		n.setLeftChild(n.leftChild)
-		n.setMiddleChild(newNode.leftChild)
-		n.setRightChild(newNode.middleChild)
-		n.resetNodeValue(n.leftValue, nil, newNode.leftValue)
-	} else {
-		panic("error node")
-	}
-}
-
-func (n *tttNode) newSplitNodeType3(originNode, newNode *tttNode) *tttNode {
-	newRootNode := &tttNode{}
-	newLeftChild := &tttNode{}
-	newMiddleChild := &tttNode{}
-	switch {
-	//                      E
-	//                     /|
-	//     E,J       C    C J
-	//    / | \  +  /| = /| |\
-	// A,D  H  L   A D  A D H L
-	case n.leftChild == originNode:
-		newRootNode.leftValue = n.leftValue
-		newLeftChild = newNode
-		newMiddleChild.leftValue = n.rightValue
-		newMiddleChild.setLeftChild(n.middleChild)
-		newMiddleChild.setMiddleChild(n.rightChild)
-	//                        G
-	//                       /|
-	//     E,J       G      E J
-	//    / | \  +  /| =   /| |\
-	// A,D F,G L   F H  A,D F H L
-	case n.middleChild == originNode:
-		newRootNode.leftValue = newNode.leftValue
-		newLeftChild.leftValue = n.leftValue
-		newLeftChild.setLeftChild(n.leftChild)
-		newLeftChild.setMiddleChild(newNode.leftChild)
-		newMiddleChild.leftValue = n.rightValue
-		newMiddleChild.setLeftChild(newNode.middleChild)
-		newMiddleChild.setMiddleChild(n.rightChild)
-	//                        J
-	//                       /|
-	//     E,J         L    C L
-	//    / | \    +  /| = /| |\
-	// A,D  H  K,L   K M  A D K M
-	case n.rightChild == originNode:
-		newRootNode.leftValue = n.rightValue
-		newMiddleChild = newNode
-		newLeftChild.leftValue = n.leftValue
-		newLeftChild.setLeftChild(n.leftChild)
-		newLeftChild.setMiddleChild(n.middleChild)
-	default:
-		panic("error node")
-	}
-	newRootNode.setLeftChild(newLeftChild)
-	newRootNode.setMiddleChild(newMiddleChild)
-	return newRootNode
+// index rehashes on the given table, a hash index of an older table may be out of date after a resize
+func (m *LockFreeReadHashMap[K, V]) index(k K) func(*lockFreeTable[K, V]) int {
+	return func(table *lockFreeTable[K, V]) int {
+		return m.hasher.Hash(k, uint(len(table.buckets)))
+	}
 }
 
-func (n *tttNode) setLeftChild(child *tttNode) {
-	n.leftChild = child
-	if child != nil {
-		child.parentNode = n
+func (m *LockFreeReadHashMap[K, V]) Get(k K) (V, bool) {
+	table := m.data.table.Load()
+	if node := table.buckets[m.index(k)(table)].Load().find(k); node != nil {
+		return node.value.v, true
 	}
+	return *new(V), false
 }
 
-func (n *tttNode) setMiddleChild(child *tttNode) {
-	n.middleChild = child
-	if child != nil {
-		child.parentNode = n
+func (m *LockFreeReadHashMap[K, V]) Set(k K, v V) {
+	var exists bool
+	var length int
+	m.data.update(func(table *lockFreeTable[K, V]) int {
+		length = len(table.buckets)
+		return m.index(k)(table)
+	}, func(head *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+		head, exists = head.set(&HashValue[K, V]{k: k, v: v})
+		return head
+	})
+	if !exists && float64(m.useCount.Add(1))/float64(length) > DEFAULT_LOAD_FACTOR {
+		m.grow(length)
 	}
 }
 
-func (n *tttNode) setRightChild(child *tttNode) {
-	n.rightChild = child
-	if child != nil {
-		child.parentNode = n
+// grow doubles the table unless another writer has already grown it from length
+func (m *LockFreeReadHashMap[K, V]) grow(length int) {
+	m.data.resize.Lock()
+	defer m.data.resize.Unlock()
+	if m.data.Len() == length {
+		m.data.reallocate(uint(length)<<1, m.hasher)
 	}
 }
 
-func (n *tttNode) setMiddleLeftChild(child *tttNode) {
-	n.middleLeftChild = child
-	if child != nil {
-		child.parentNode = n
+func (m *LockFreeReadHashMap[K, V]) Del(k K) (V, bool) {
+	var hashValue *HashValue[K, V]
+	m.data.update(m.index(k), func(head *lockFreeNode[K, V]) *lockFreeNode[K, V] {
+		head, hashValue = head.del(k)
+		return head
+	})
+	if hashValue == nil {
+		return *new(V), false
 	}
+	m.useCount.Add(-1)
+	return hashValue.v, true
 }
 
-func (n *tttNode) setMiddleRightChild(child *tttNode) {
-	n.middleRightChild = child
-	if child != nil {
-		child.parentNode = n
-	}
+func (m *LockFreeReadHashMap[K, V]) Len() int {
+	return int(m.useCount.Load())
+}
+
+func (m *LockFreeReadHashMap[K, V]) Range(op func(k K, v V) bool) {
+	m.data.Range(func(hashValue *HashValue[K, V]) bool {
+		return op(hashValue.k, hashValue.v)
+	})
 }
 
-type tttNodeType int
+// ----------------------------------------------------------------
+
+// persistent hash map, hash array mapped trie - HAMT
+
+// every level takes HAMT_BITS bits of the hash, Set and Del copy only the nodes on the path from root to the key,
+// all other nodes are shared between the old map and the new one, so every version stays valid and immutable
 
 const (
-	errorNode tttNodeType = iota
-	twoChildren
-	threeChildren
-	fourChildren
+	HAMT_BITS      = 5
+	HAMT_HASH_BITS = 32 // levels below HAMT_HASH_BITS keep colliding keys in a list
+	HAMT_HASH_MASK = uint64(1)<<HAMT_HASH_BITS - 1
 )
 
-func (n *tttNode) getNodeType() tttNodeType {
-	if n.leftValue != nil {
-		if n.rightValue != nil {
-			return threeChildren
-		} else {
-			return twoChildren
-		}
-	}
-	if n.rightValue != nil {
-		// TODO: panic here
-		return twoChildren
-	}
-	// TODO: panic here
-	return errorNode
+type hamtEntry[K comparable, V any] struct {
+	hash  uint64
+	value *HashValue[K, V] // nil if child is not nil
+	child *hamtNode[K, V]
 }
 
-type tttHashMapData struct {
-	buckets []*tttNode
+type hamtNode[K comparable, V any] struct {
+	bitmap     uint32             // bit i is set if slot i is used
+	entries    []hamtEntry[K, V]  // one entry per set bit, ordered by slot
+	collisions []*HashValue[K, V] // keys sharing the whole hash, only for nodes below the last level
 }
 
-func (d *tttHashMapData) Len() int {
-	return len(d.buckets)
+func (n *hamtNode[K, V]) position(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
 }
 
-func (d *tttHashMapData) Get(hashIndex, key int) (int, bool) {
-	if d.buckets[hashIndex] == nil {
-		return 0, false
-	} else {
-		node := d.buckets[hashIndex]
-		for {
-			if node.leftValue != nil {
-				switch {
-				case node.leftValue.k == key:
-					return node.leftValue.v, true
-				case key < node.leftValue.k:
-					if node.leftChild != nil {
-						node = node.leftChild
-						continue
-					} else {
-						return 0, false
-					}
-				}
-			}
-			if node.rightValue != nil {
-				switch {
-				case node.rightValue.k == key:
-					return node.rightValue.v, true
-				case node.rightValue.k < key:
-					if node.rightChild != nil {
-						node = node.rightChild
-						continue
-					} else {
-						return 0, false
-					}
+func (n *hamtNode[K, V]) get(hash uint64, shift uint, key K) *HashValue[K, V] {
+	for n != nil {
+		if shift >= HAMT_HASH_BITS {
+			for _, hashValue := range n.collisions {
+				if hashValue.k == key {
+					return hashValue
 				}
 			}
-			if node.middleChild != nil {
-				node = node.middleChild
-			} else {
-				return 0, false
+			return nil
+		}
+		bit := uint32(1) << ((hash >> shift) & (1<<HAMT_BITS - 1))
+		if n.bitmap&bit == 0 {
+			return nil
+		}
+		entry := n.entries[n.position(bit)]
+		if entry.child == nil {
+			if entry.value.k == key {
+				return entry.value
 			}
+			return nil
 		}
+		n, shift = entry.child, shift+HAMT_BITS
 	}
+	return nil
 }
 
-func (d *tttHashMapData) set(hashIndex int, insertHashValue *HashValue) bool {
-	if d.buckets[hashIndex] == nil {
-		d.buckets[hashIndex] = &tttNode{
-			leftValue: insertHashValue,
-		}
+// withEntry copies the node with the entry at position replaced, or inserted if insert is true
+func (n *hamtNode[K, V]) withEntry(bit uint32, entry hamtEntry[K, V], insert bool) *hamtNode[K, V] {
+	position := n.position(bit)
+	newNode := &hamtNode[K, V]{
+		bitmap: n.bitmap | bit,
+	}
+	if insert {
+		newNode.entries = make([]hamtEntry[K, V], 0, len(n.entries)+1)
+		newNode.entries = append(newNode.entries, n.entries[:position]...)
+		newNode.entries = append(newNode.entries, entry)
+		newNode.entries = append(newNode.entries, n.entries[position:]...)
 	} else {
-		node := d.buckets[hashIndex]
-		hashValue := insertHashValue
-		var leftChild, middleChild *tttNode
-		fromSplit := false
-	INSERT:
-		for {
-			// 左边界
-			if node.leftValue != nil {
-				switch {
-				case node.leftValue.k == hashValue.k: // 左等
-					node.leftValue.v = hashValue.v
-					goto SPLIT
-				case hashValue.k < node.leftValue.k: // 左左
-					if !fromSplit && node.leftChild != nil {
-						node = node.leftChild
-						continue
-					} else {
-						if node.rightValue == nil {
-							// insert 1
-							// 2 -> 1,2
-							node.resetNodeValue(hashValue, nil, node.leftValue)
-							// split
-							//      5        2,5
-							// 1,2,3 6 -> 1,3   6
-							if fromSplit {
-								node.setRightChild(node.middleChild)
-								node.setMiddleChild(middleChild)
-								node.setLeftChild(leftChild)
-							}
-						} else {
-							// insert 1
-							// 2,3 -> 1,2,3
-							node.resetNodeValue(hashValue, node.leftValue, node.rightValue)
-							node.setLeftChild(leftChild)
-							node.setMiddleLeftChild(middleChild)
-							node.setMiddleRightChild(node.middleChild)
-							node.setMiddleChild(nil)
-						}
-						goto SPLIT
-					}
-				}
-			}
-			// 右边界
-			if node.rightValue != nil {
-				switch {
-				case node.rightValue.k == hashValue.k: // 右等
-					node.rightValue.v = hashValue.v
-					goto SPLIT
-				case node.rightValue.k < hashValue.k: // 右右
-					if !fromSplit && node.rightChild != nil {
-						node = node.rightChild
-						continue
-					} else {
-						if node.leftValue == nil {
-							// TODO: panic here
-							// insert 3
-							// 2 -> 2,3
-							node.resetNodeValue(node.rightValue, nil, hashValue)
-						} else {
-							// insert 3
-							// 1,2 -> 1,2,3
-							node.resetNodeValue(node.leftValue, node.rightValue, hashValue)
-							node.setRightChild(middleChild)
-							node.setMiddleLeftChild(node.middleChild)
-							node.setMiddleRightChild(leftChild)
-							node.setMiddleChild(nil)
-						}
-						goto SPLIT
-					}
-				}
-			}
-			// 中子树
-			if node.middleChild != nil { // 左右/右左
-				// 从中间分裂上来
-				if fromSplit {
-					if node.rightValue != nil {
-						node.resetNodeValue(node.leftValue, hashValue, node.rightValue)
-						node.setMiddleLeftChild(leftChild)
-						node.setMiddleRightChild(middleChild)
-						node.setMiddleChild(nil)
-					} else {
-						node.resetNodeValue(node.leftValue, nil, hashValue)
-						node.setMiddleChild(leftChild)
-						node.setRightChild(middleChild)
-					}
-					goto SPLIT
-				} else {
-					node = node.middleChild
-					continue
-				}
-			} else {
-				// insert 2
-				// 1,3 -> 1,2,3
-				if fromSplit {
-					node.resetNodeValue(node.leftValue, nil, hashValue)
-					node.setMiddleChild(leftChild)
-					node.setRightChild(middleChild)
-				} else {
-					if node.rightValue != nil {
-						node.resetNodeValue(node.leftValue, hashValue, node.rightValue)
-					} else {
-						node.resetNodeValue(node.leftValue, nil, hashValue)
-					}
-				}
-				goto SPLIT
-			}
-		}
-	SPLIT:
-		parentNode := node.parentNode
-		if node.middleValue != nil {
-			newRootNode := node.splitNode()
-			node.middleValue = nil
-			if parentNode != nil {
-				node = parentNode
-				hashValue = newRootNode.leftValue
-				leftChild = newRootNode.leftChild
-				middleChild = newRootNode.middleChild
-				fromSplit = true
-				goto INSERT
-			} else {
-				d.buckets[hashIndex] = newRootNode
-			}
-		}
+		newNode.entries = append([]hamtEntry[K, V](nil), n.entries...)
+		newNode.entries[position] = entry
 	}
-
-	fmt.Println()
-	fmt.Printf("after insert %v\n", insertHashValue.k)
-	d.buckets[hashIndex].preOrderTraversal(func(h *HashValue) bool {
-		fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
-		return true
-	}, 0)
-
-	return true
+	return newNode
 }
 
-type InsertType int
-
-const (
-	InsertError InsertType = iota
-	InsertLeft
-	InsertRight
-	InsertMiddle
-)
+// withoutEntry copies the node without the entry of bit, nil if nothing is left
+func (n *hamtNode[K, V]) withoutEntry(bit uint32) *hamtNode[K, V] {
+	if n.bitmap == bit {
+		return nil
+	}
+	position := n.position(bit)
+	newNode := &hamtNode[K, V]{
+		bitmap:  n.bitmap &^ bit,
+		entries: make([]hamtEntry[K, V], 0, len(n.entries)-1),
+	}
+	newNode.entries = append(newNode.entries, n.entries[:position]...)
+	newNode.entries = append(newNode.entries, n.entries[position+1:]...)
+	return newNode
+}
 
-func (d *tttHashMapData) Set(hashIndex int, insertHashValue *HashValue) bool {
-	if d.buckets[hashIndex] == nil {
-		d.buckets[hashIndex] = &tttNode{
-			leftValue: insertHashValue,
-		}
-	} else {
-		insertType := InsertError
-		node := d.buckets[hashIndex]
-		for {
-			if node.leftValue != nil {
-				switch {
-				case node.leftValue.k == insertHashValue.k:
-					node.leftValue.v = insertHashValue.v
-					return true
-				case insertHashValue.k < node.leftValue.k:
-					if node.leftChild != nil {
-						node = node.leftChild
-						continue
-					} else {
-						insertType = InsertLeft
-						goto SPLIT
-					}
-				}
-			}
-			if node.rightValue != nil {
-				switch {
-				case node.rightValue.k == insertHashValue.k:
-					node.rightValue.v = insertHashValue.v
-					return true
-				case node.rightValue.k < insertHashValue.k:
-					if node.rightChild != nil {
-						node = node.rightChild
-						continue
-					} else {
-						insertType = InsertRight
-						goto SPLIT
-					}
-				}
-			}
-			if node.middleChild != nil {
-				node = node.middleChild
-			} else {
-				if node.rightValue == nil {
-					insertType = InsertRight
-				} else if node.leftValue == nil {
-					insertType = InsertLeft
-					panic("error node")
-				} else {
-					insertType = InsertMiddle
-				}
-				goto SPLIT
-			}
+// set returns the new node and whether a key is added, n may be nil
+func (n *hamtNode[K, V]) set(hash uint64, shift uint, hashValue *HashValue[K, V]) (*hamtNode[K, V], bool) {
+	if n == nil {
+		n = &hamtNode[K, V]{}
+	}
+	if shift >= HAMT_HASH_BITS {
+		newNode := &hamtNode[K, V]{
+			collisions: append([]*HashValue[K, V](nil), n.collisions...),
 		}
-	SPLIT:
-		switch node.getNodeType() {
-		case twoChildren:
-			switch insertType {
-			case InsertLeft:
-				node.resetNodeValue(insertHashValue, nil, node.leftValue)
-			case InsertRight:
-				node.resetNodeValue(node.leftValue, nil, insertHashValue)
-			default:
-				panic("error insert type")
-			}
-		case threeChildren:
-			if insertType == InsertError {
-				panic("error insert type")
-			}
-			if node.parentNode == nil {
-				d.buckets[hashIndex] = node.newSplitNodeType1(insertHashValue, insertType)
-			} else {
-				switch node.parentNode.getNodeType() {
-				case twoChildren:
-					newRootNode := node.newSplitNodeType1(insertHashValue, insertType)
-					node.parentNode.newSplitNodeType2(node, newRootNode)
-				case threeChildren:
-					newRootNode := node.newSplitNodeType1(insertHashValue, insertType)
-				RESPLIT:
-					newRootNode = node.parentNode.newSplitNodeType3(node, newRootNode)
-					node = node.parentNode
-					if node.parentNode == nil {
-						d.buckets[hashIndex] = newRootNode
-					} else {
-						goto RESPLIT
-					}
-				default:
-					panic("error node type")
-				}
+		for index, collision := range newNode.collisions {
+			if collision.k == hashValue.k {
+				newNode.collisions[index] = hashValue
+				return newNode, false
 			}
-		default:
-			panic("error node type")
 		}
+		newNode.collisions = append(newNode.collisions, hashValue)
+		return newNode, true
 	}
-
-	fmt.Println()
-	fmt.Printf("after insert %v\n", insertHashValue.k)
-	d.buckets[hashIndex].preOrderTraversal(func(h *HashValue) bool {
-		fmt.Printf("DEBUG: range key: %v, value: %v\n", h.k, h.v)
-		return true
-	}, 0)
-
-	return true
+	bit := uint32(1) << ((hash >> shift) & (1<<HAMT_BITS - 1))
+	if n.bitmap&bit == 0 {
+		return n.withEntry(bit, hamtEntry[K, V]{hash: hash, value: hashValue}, true), true
+	}
+	entry := n.entries[n.position(bit)]
+	if entry.child != nil {
+		child, added := entry.child.set(hash, shift+HAMT_BITS, hashValue)
+		return n.withEntry(bit, hamtEntry[K, V]{child: child}, false), added
+	}
+	if entry.value.k == hashValue.k {
+		return n.withEntry(bit, hamtEntry[K, V]{hash: hash, value: hashValue}, false), false
+	}
+	// 两个键在本层冲突，下沉到新的子节点
+	child, _ := (*hamtNode[K, V])(nil).set(entry.hash, shift+HAMT_BITS, entry.value)
+	child, _ = child.set(hash, shift+HAMT_BITS, hashValue)
+	return n.withEntry(bit, hamtEntry[K, V]{child: child}, false), true
 }
 
-func (d *tttHashMapData) Del(hashIndex, key int) (int, bool) {
-	if d.buckets[hashIndex] == nil {
-		return 0, false
-	} else {
-		node := d.buckets[hashIndex]
-		var value int
-		var deleteNode *tttNode
-		for {
-			if node.leftValue != nil {
-				switch {
-				case node.leftValue.k == key:
-					value = node.leftValue.v
-					deleteNode = node
-					node.resetNodeValue(node.rightValue, nil, nil)
-					goto REBALANCE
-				case key < node.leftValue.k:
-					node = node.leftChild
-					continue
+// del returns the new node and the deleted value, the node itself and nil if key does not exist
+func (n *hamtNode[K, V]) del(hash uint64, shift uint, key K) (*hamtNode[K, V], *HashValue[K, V]) {
+	if n == nil {
+		return nil, nil
+	}
+	if shift >= HAMT_HASH_BITS {
+		for index, collision := range n.collisions {
+			if collision.k == key {
+				if len(n.collisions) == 1 {
+					return nil, collision
 				}
-			}
-			if node.rightValue != nil {
-				switch {
-				case node.rightValue.k == key:
-					value = node.rightValue.v
-					deleteNode = node
-					node.resetNodeValue(node.leftValue, nil, nil)
-					goto REBALANCE
-				case node.rightValue.k < key:
-					node = node.rightChild
-					continue
+				newNode := &hamtNode[K, V]{
+					collisions: make([]*HashValue[K, V], 0, len(n.collisions)-1),
 				}
+				newNode.collisions = append(newNode.collisions, n.collisions[:index]...)
+				newNode.collisions = append(newNode.collisions, n.collisions[index+1:]...)
+				return newNode, collision
 			}
-			node = node.middleChild
-		}
-	REBALANCE:
-		if deleteNode.leftValue != nil || deleteNode.rightValue != nil {
-			return value, true
 		}
-		return value, true
+		return n, nil
 	}
-}
-
-func (d *tttHashMapData) Range(op func(*HashValue) bool) {
-	for _, bucket := range d.buckets {
-		if bucket != nil {
-			fmt.Println()
-			fmt.Printf("preOrderTraversal\n")
-			if !bucket.preOrderTraversal(op, 0) {
-				return
-			}
+	bit := uint32(1) << ((hash >> shift) & (1<<HAMT_BITS - 1))
+	if n.bitmap&bit == 0 {
+		return n, nil
+	}
+	entry := n.entries[n.position(bit)]
+	if entry.child == nil {
+		if entry.value.k != key {
+			return n, nil
 		}
+		return n.withoutEntry(bit), entry.value
 	}
-}
-
-func (d *tttHashMapData) Reallocate(size uint) {
-	if uint(len(d.buckets)) != size {
-		d.buckets = make([]*tttNode, size)
+	child, hashValue := entry.child.del(hash, shift+HAMT_BITS, key)
+	if hashValue == nil {
+		return n, nil
 	}
-	// TODO: move data
-}
-
-// ----------------------------------------------------------------
-
-type HashMap struct {
-	loadFactor float64     // allocator
-	useCount   uint        // allocator
-	data       HashMapData // data structure
-	hashFunc   func(int, uint) int
+	if child == nil {
+		return n.withoutEntry(bit), hashValue
+	}
+	if len(child.entries) == 1 && child.entries[0].child == nil {
+		// 子节点只剩一个值时上提，保持路径最短
+		return n.withEntry(bit, child.entries[0], false), hashValue
+	}
+	return n.withEntry(bit, hamtEntry[K, V]{child: child}, false), hashValue
 }
 
-func (h *HashMap) Set(k, v int) bool {
-	hashIndex := h.hashFunc(k, uint(h.data.Len()))
-	if hashIndex < 0 || h.data.Len() <= hashIndex {
-		return false
+func (n *hamtNode[K, V]) inOrderTraversal(op func(*HashValue[K, V]) bool) bool {
+	if n == nil {
+		return true
 	}
-	if ok := h.data.Set(hashIndex, &HashValue{
-		k: k,
-		v: v,
-	}); !ok {
-		return false
+	for _, hashValue := range n.collisions {
+		if !op(hashValue) {
+			return false
+		}
+	}
+	for _, entry := range n.entries {
+		if entry.child == nil {
+			if !op(entry.value) {
+				return false
+			}
+		} else if !entry.child.inOrderTraversal(op) {
+			return false
+		}
 	}
-	h.useCount++
 	return true
 }
 
-func (h *HashMap) Get(k int) (int, bool) {
-	hashIndex := h.hashFunc(k, uint(h.data.Len()))
-	if hashIndex < 0 || h.data.Len() <= hashIndex {
-		return 0, false
-	}
-	return h.data.Get(hashIndex, k)
+// PersistentHashMap is immutable, the zero value is an empty map with defaultHasher.
+// It is safe to share any version between goroutines without locking
+type PersistentHashMap[K comparable, V any] struct {
+	root     *hamtNode[K, V]
+	useCount int
+	hasher   Hasher[K]
 }
 
-func (h *HashMap) Del(k int) (int, bool) {
-	hashIndex := h.hashFunc(k, uint(h.data.Len()))
-	if hashIndex < 0 || h.data.Len() <= hashIndex {
-		return 0, false
-	}
-	v, ok := h.data.Del(hashIndex, k)
-	if !ok {
-		return 0, false
+// MakePersistentHashMap uses defaultHasher if hasher is nil
+func MakePersistentHashMap[K comparable, V any](hasher Hasher[K]) *PersistentHashMap[K, V] {
+	return &PersistentHashMap[K, V]{
+		hasher: hasher,
 	}
-	h.useCount--
-	return v, true
 }
 
-func (h *HashMap) GetLoadFactor(delta uint) float64 {
-	return float64(h.useCount+delta) / float64(h.data.Len())
+// hash keeps the low HAMT_HASH_BITS bits of the mixed hash64, or of a custom hasher over MAX_HASHER_SIZE,
+// mixing first keeps integer keys differing only in the high bits out of one collision list
+func (m *PersistentHashMap[K, V]) hash(k K) uint64 {
+	if m.hasher == nil {
+		return mix64(hash64(k)) & HAMT_HASH_MASK
+	}
+	return uint64(m.hasher.Hash(k, MAX_HASHER_SIZE)) & HAMT_HASH_MASK
 }
 
-func (h *HashMap) Range(op func(k, v int) bool) {
-	h.data.Range(func(hashValue *HashValue) bool {
-		return op(hashValue.k, hashValue.v)
-	})
+func (m *PersistentHashMap[K, V]) Len() int {
+	return m.useCount
 }
 
-type HashMapOption func(*HashMap)
-
-func MakeHashMap(options ...HashMapOption) *HashMap {
-	hashMap := &HashMap{
-		loadFactor: DEFAULT_LOAD_FACTOR,
-		data: &ldhHashMapData{
-			array: make([]*HashValue, DEFAULT_HASH_MAP_SIZE),
-		},
-		hashFunc: defaultHashFunc,
+func (m *PersistentHashMap[K, V]) Get(k K) (V, bool) {
+	if hashValue := m.root.get(m.hash(k), 0, k); hashValue != nil {
+		return hashValue.v, true
 	}
-	for _, option := range options {
-		option(hashMap)
-	}
-	return hashMap
+	return *new(V), false
 }
 
-func WithHashMapLoadFactor(factor float64) HashMapOption {
-	return func(h *HashMap) {
-		h.loadFactor = factor
+// Set returns a new map with k set to v, m is not changed
+func (m *PersistentHashMap[K, V]) Set(k K, v V) *PersistentHashMap[K, V] {
+	root, added := m.root.set(m.hash(k), 0, &HashValue[K, V]{
+		k: k,
+		v: v,
+	})
+	newMap := &PersistentHashMap[K, V]{
+		root:     root,
+		useCount: m.useCount,
+		hasher:   m.hasher,
 	}
-}
-
-func WithHashMapData(data HashMapData) HashMapOption {
-	return func(h *HashMap) {
-		h.data = data
+	if added {
+		newMap.useCount++
 	}
+	return newMap
 }
 
-func WithHashMapSize(size uint) HashMapOption {
-	return func(h *HashMap) {
-		if h.data != nil {
-			h.data.Reallocate(size)
-		}
+// Del returns a new map without k and the deleted value, m itself if k does not exist
+func (m *PersistentHashMap[K, V]) Del(k K) (*PersistentHashMap[K, V], V, bool) {
+	root, hashValue := m.root.del(m.hash(k), 0, k)
+	if hashValue == nil {
+		return m, *new(V), false
 	}
+	return &PersistentHashMap[K, V]{
+		root:     root,
+		useCount: m.useCount - 1,
+		hasher:   m.hasher,
+	}, hashValue.v, true
 }
 
-func WithHashMapHashFunc(f func(int, uint) int) HashMapOption {
-	return func(h *HashMap) {
-		h.hashFunc = f
-	}
+func (m *PersistentHashMap[K, V]) Range(op func(k K, v V) bool) {
+	m.root.inOrderTraversal(func(hashValue *HashValue[K, V]) bool {
+		return op(hashValue.k, hashValue.v)
+	})
 }
 
 func main() {
 	seed := time.Now().UnixNano()
 	fmt.Printf("seed is %v\n", seed)
 	rand.Seed(seed)
+
 	for index := 0; index != 10000; index++ {
 		fmt.Println()
 		keyValueMap := make(map[int]int)
@@ -1851,30 +6288,30 @@ func main() {
 			}
 		}
 
-		// hashMapTest(keyValueMap, WithHashMapSize(DEFAULT_HASH_MAP_SIZE>>9))
+		// hashMapTest(keyValueMap, WithHashMapSize[int, int](DEFAULT_HASH_MAP_SIZE>>9))
 
-		// hashMapTest(keyValueMap, WithHashMapData(&sdhHashMapData{
-		// 	array: make([]*HashValue, DEFAULT_HASH_MAP_SIZE>>9),
+		// hashMapTest(keyValueMap, WithHashMapData[int, int](&sdhHashMapData[int, int]{
+		// 	array: make([]*HashValue[int, int], DEFAULT_HASH_MAP_SIZE>>9),
 		// }))
 
-		// hashMapTest(keyValueMap, WithHashMapData(&dllHashMapData{
-		// 	buckets: make([]*dllNode, DEFAULT_HASH_MAP_SIZE>>9),
+		// hashMapTest(keyValueMap, WithHashMapData[int, int](&dllHashMapData[int, int]{
+		// 	buckets: make([]*dllNode[int, int], DEFAULT_HASH_MAP_SIZE>>9),
 		// }))
 
-		// hashMapTest(keyValueMap, WithHashMapData(&bstHashMapData{
-		// 	buckets: make([]*bstNode, DEFAULT_HASH_MAP_SIZE>>10),
+		// hashMapTest(keyValueMap, WithHashMapData[int, int](&bstHashMapData[int, int]{
+		// 	buckets: make([]*bstNode[int, int], DEFAULT_HASH_MAP_SIZE>>10),
 		// }))
 
-		// hashMapTest(seed, index, keyValueMap, WithHashMapData(&avltHashMapData{
-		// 	buckets: make([]*avltNode, DEFAULT_HASH_MAP_SIZE>>10),
+		// hashMapTest(seed, index, keyValueMap, WithHashMapData[int, int](&avltHashMapData[int, int]{
+		// 	buckets: make([]*avltNode[int, int], DEFAULT_HASH_MAP_SIZE>>10),
 		// }))
 
-		hashMapTest(seed, index, keyValueMap, WithHashMapData(&tttHashMapData{
-			buckets: make([]*tttNode, DEFAULT_HASH_MAP_SIZE>>10),
+		hashMapTest(seed, index, keyValueMap, WithHashMapData[int, int](&tttHashMapData[int, int]{
+			buckets: make([]*tttNode[int, int], DEFAULT_HASH_MAP_SIZE>>10),
 		}))
 
-		// hashMapDebug(seed, index, debugKeyValueMap, WithHashMapData(&tttHashMapData{
-		// 	buckets: make([]*tttNode, DEFAULT_HASH_MAP_SIZE>>10),
+		// hashMapDebug(seed, index, debugKeyValueMap, WithHashMapData[int, int](&tttHashMapData[int, int]{
+		// 	buckets: make([]*tttNode[int, int], DEFAULT_HASH_MAP_SIZE>>10),
 		// }))
 	}
 }
@@ -1936,7 +6373,7 @@ var (
 	debugDelSlice = []int{}
 )
 
-func hashMapDebug(seed int64, index int, keyValueMap map[int]int, options ...HashMapOption) {
+func hashMapDebug(seed int64, index int, keyValueMap map[int]int, options ...HashMapOption[int, int]) {
 	debugHashMap := MakeHashMap(options...)
 
 	debugData := debugData{
@@ -1989,7 +6426,7 @@ func hashMapDebug(seed int64, index int, keyValueMap map[int]int, options ...Has
 	// })
 }
 
-func hashMapTest(seed int64, index int, keyValueMap map[int]int, options ...HashMapOption) {
+func hashMapTest(seed int64, index int, keyValueMap map[int]int, options ...HashMapOption[int, int]) {
 	testHashMap := MakeHashMap(options...)
 
 	debugData := debugData{
@@ -0,0 +1,981 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testBackend allocates an empty data structure of one backend, options tune the HashMap for it
+type testBackend struct {
+	name     string
+	allocate func(uint) HashMapData[int, int]
+	options  []HashMapOption[int, int]
+}
+
+// testBackends covers every data structure, tree buckets hold a few keys each so that rotations and splits happen
+var testBackends = []testBackend{
+	{name: "ldh", allocate: (&ldhHashMapData[int, int]{}).Allocate},
+	{name: "sdh", allocate: (&sdhHashMapData[int, int]{}).Allocate},
+	{name: "double", allocate: (&doubleHashMapData[int, int]{}).Allocate},
+	{name: "randomProbe", allocate: (&randomProbeHashMapData[int, int]{seed: 7}).Allocate},
+	{name: "robinHood", allocate: (&robinHoodHashMapData[int, int]{}).Allocate},
+	{name: "cuckoo", allocate: (&cuckooHashMapData[int, int]{}).Allocate},
+	{name: "hopscotch", allocate: (&hopscotchHashMapData[int, int]{}).Allocate},
+	{name: "swiss", allocate: (&swissHashMapData[int, int]{}).Allocate},
+	{name: "dll", allocate: (&dllHashMapData[int, int]{}).Allocate},
+	{name: "bst", allocate: (&bstHashMapData[int, int]{}).Allocate},
+	{name: "avlt", allocate: (&avltHashMapData[int, int]{}).Allocate},
+	{name: "treeify", allocate: (&treeifyHashMapData[int, int]{}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](40),
+	}},
+	{name: "rbt", allocate: (&rbtHashMapData[int, int]{}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](20),
+	}},
+	{name: "skipList", allocate: (&skipListHashMapData[int, int]{maxLevel: 4, seed: 7}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](20),
+	}},
+	{name: "st", allocate: (&stHashMapData[int, int]{}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](20),
+	}},
+	{name: "tp", allocate: (&tpHashMapData[int, int]{seed: 7}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](20),
+	}},
+	{name: "ttt", allocate: (&tttHashMapData[int, int]{}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](20),
+	}},
+	{name: "btree", allocate: (&btreeHashMapData[int, int]{degree: 2}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](20),
+	}},
+	{name: "extendible", allocate: (&extendibleHashMapData[int, int]{bucketSize: 4}).Allocate},
+	{name: "linear", allocate: (&linearHashMapData[int, int]{}).Allocate, options: []HashMapOption[int, int]{
+		WithHashMapLoadFactor[int, int](2),
+	}},
+	{name: "lockFree", allocate: (&lockFreeHashMapData[int, int]{}).Allocate},
+}
+
+// testHashMaps runs test on a small HashMap of every backend, rehashing at once and incrementally
+func testHashMaps(t *testing.T, test func(*testing.T, *HashMap[int, int])) {
+	for _, backend := range testBackends {
+		for _, evacuateCount := range []int{0, 1} {
+			options := append([]HashMapOption[int, int]{
+				WithHashMapData(backend.allocate(8)),
+			}, backend.options...)
+			if evacuateCount != 0 {
+				options = append(options, WithHashMapIncrementalRehash[int, int](evacuateCount))
+			}
+			t.Run(fmt.Sprintf("%v/evacuate=%v", backend.name, evacuateCount), func(t *testing.T) {
+				test(t, MakeHashMap(options...))
+			})
+		}
+	}
+}
+
+// checkHashMap compares Len, Get and Range of testHashMap with expect
+func checkHashMap(t *testing.T, testHashMap *HashMap[int, int], expect map[int]int) {
+	t.Helper()
+	if int(testHashMap.useCount) != len(expect) {
+		t.Fatalf("use count %v not equal to %v", testHashMap.useCount, len(expect))
+	}
+	// avlt, treeify, rbt and btree panic if any bucket breaks the tree invariants
+	if validator, ok := testHashMap.data.(interface{ validateCheck() }); ok {
+		validator.validateCheck()
+	}
+	// Get evacuates with incremental rehash, so it can not be called inside Range
+	visited := make(map[int]int, len(expect))
+	testHashMap.Range(func(k, v int) bool {
+		if _, hasKey := visited[k]; hasKey {
+			t.Fatalf("Range() visits key %v twice", k)
+		}
+		visited[k] = v
+		return true
+	})
+	if len(visited) != len(expect) {
+		t.Fatalf("Range() visits %v keys not equal to %v", len(visited), len(expect))
+	}
+	for key, value := range expect {
+		if _value, hasKey := testHashMap.Get(key); !hasKey || _value != value || visited[key] != value {
+			t.Fatalf("Get(%v) = %v, %v and Range() value %v not equal to origin value %v", key, _value, hasKey, visited[key], value)
+		}
+	}
+}
+
+func TestHashMap(t *testing.T) {
+	testHashMaps(t, func(t *testing.T, testHashMap *HashMap[int, int]) {
+		random := rand.New(rand.NewSource(1))
+		expect := make(map[int]int)
+		for index := 0; index != 20000; index++ {
+			key := random.Intn(3000)
+			if random.Intn(3) != 0 {
+				if !testHashMap.Set(key, index) {
+					t.Fatalf("Set(%v, %v) failed", key, index)
+				}
+				expect[key] = index
+			} else {
+				value, hasKey := testHashMap.Del(key)
+				expectValue, expectHasKey := expect[key]
+				if hasKey != expectHasKey || value != expectValue {
+					t.Fatalf("Del(%v) = %v, %v not equal to %v, %v", key, value, hasKey, expectValue, expectHasKey)
+				}
+				delete(expect, key)
+			}
+			if index%1000 == 0 {
+				checkHashMap(t, testHashMap, expect)
+			}
+		}
+		checkHashMap(t, testHashMap, expect)
+	})
+}
+
+// TestSDHFullTable sets into every slot of an array allocated at a size that is not a power of two
+func TestSDHFullTable(t *testing.T) {
+	data := (&sdhHashMapData[int, int]{}).Allocate(100)
+	if data.Len() != 128 {
+		t.Fatalf("Allocate(100) Len() %v not equal to 128", data.Len())
+	}
+	for key := 0; key != data.Len(); key++ {
+		// every key starts probing at the same slot, so Set succeeds only if probe visits every slot
+		if !data.Set(0, &HashValue[int, int]{k: key, v: key}) {
+			t.Fatalf("Set(%v) failed with %v keys in %v slots", key, key, data.Len())
+		}
+	}
+	if data.Set(0, &HashValue[int, int]{k: -1}) {
+		t.Fatalf("Set() succeeds on a full array")
+	}
+}
+
+func TestWithHashMapSize(t *testing.T) {
+	for size, expect := range map[uint]int{1: 1, 2: 2, 100: 128, 1024: 1024, 1025: 2048} {
+		testHashMap := MakeHashMap(WithHashMapSize[int, int](size))
+		if testHashMap.data.Len() != expect {
+			t.Fatalf("WithHashMapSize(%v) Len() %v not equal to %v", size, testHashMap.data.Len(), expect)
+		}
+		// every index is reachable, so Set fills the table up to the load factor without growing
+		for key := 0; key != expect*3/4; key++ {
+			testHashMap.Set(key*7, key)
+		}
+		if testHashMap.data.Len() != expect {
+			t.Fatalf("WithHashMapSize(%v) grows to %v before the load factor", size, testHashMap.data.Len())
+		}
+	}
+}
+
+// TestSelfResizerHasher sets keys colliding under a custom hasher, dynamic hashing must address keys by it
+func TestSelfResizerHasher(t *testing.T) {
+	for _, backend := range testBackends {
+		if backend.name != "extendible" && backend.name != "linear" {
+			continue
+		}
+		t.Run(backend.name, func(t *testing.T) {
+			testHashMap := MakeHashMap(append([]HashMapOption[int, int]{
+				WithHashMapData(backend.allocate(8)),
+				WithHashMapHashFunc[int, int](func(k int, l uint) int {
+					return k & 3 & int(l-1)
+				}),
+			}, backend.options...)...)
+			expect := make(map[int]int)
+			for key := 0; key != 1000; key++ {
+				testHashMap.Set(key, key)
+				expect[key] = key
+			}
+			checkHashMap(t, testHashMap, expect)
+			buckets := 0
+			switch data := testHashMap.data.(type) {
+			case *extendibleHashMapData[int, int]:
+				for index, bucket := range data.directory {
+					if index < 1<<bucket.localDepth && len(bucket.values) != 0 {
+						buckets++
+					}
+				}
+			case *linearHashMapData[int, int]:
+				for _, bucket := range data.buckets {
+					if len(bucket) != 0 {
+						buckets++
+					}
+				}
+			}
+			if buckets > 4 {
+				t.Fatalf("keys of 4 hashes are stored in %v buckets", buckets)
+			}
+		})
+	}
+}
+
+// testPoint is a struct key, == treats 0 and -0 of X and Z as equal
+type testPoint struct {
+	X    float64
+	Name string
+	Tags [2]int8
+	Z    complex64
+}
+
+// testHashMapKeys runs Set, Get and Del with keys of type K on every backend taking any comparable key,
+// the two keys of each pair in sameKeys are equal under == and must be the same key of the map
+func testHashMapKeys[K comparable](t *testing.T, key func(int) K, sameKeys [][2]K) {
+	backends := []struct {
+		name     string
+		allocate func(uint) HashMapData[K, int]
+		options  []HashMapOption[K, int]
+	}{
+		{name: "ldh", allocate: (&ldhHashMapData[K, int]{}).Allocate},
+		{name: "sdh", allocate: (&sdhHashMapData[K, int]{}).Allocate},
+		{name: "double", allocate: (&doubleHashMapData[K, int]{}).Allocate},
+		{name: "randomProbe", allocate: (&randomProbeHashMapData[K, int]{seed: 7}).Allocate},
+		{name: "robinHood", allocate: (&robinHoodHashMapData[K, int]{}).Allocate},
+		{name: "cuckoo", allocate: (&cuckooHashMapData[K, int]{}).Allocate},
+		{name: "hopscotch", allocate: (&hopscotchHashMapData[K, int]{}).Allocate},
+		{name: "swiss", allocate: (&swissHashMapData[K, int]{}).Allocate},
+		{name: "dll", allocate: (&dllHashMapData[K, int]{}).Allocate},
+		{name: "extendible", allocate: (&extendibleHashMapData[K, int]{bucketSize: 4}).Allocate},
+		{name: "linear", allocate: (&linearHashMapData[K, int]{}).Allocate, options: []HashMapOption[K, int]{
+			WithHashMapLoadFactor[K, int](2),
+		}},
+		{name: "lockFree", allocate: (&lockFreeHashMapData[K, int]{}).Allocate},
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			testHashMap := MakeHashMap(append([]HashMapOption[K, int]{
+				WithHashMapData(backend.allocate(8)),
+			}, backend.options...)...)
+			for index := 0; index != 2000; index++ {
+				testHashMap.Set(key(index), index)
+			}
+			for _, pair := range sameKeys {
+				testHashMap.Set(pair[0], -1)
+				testHashMap.Set(pair[1], -2)
+				if value, hasKey := testHashMap.Get(pair[0]); !hasKey || value != -2 {
+					t.Fatalf("Get(%#v) = %v, %v after Set(%#v, -2)", pair[0], value, hasKey, pair[1])
+				}
+			}
+			if int(testHashMap.useCount) != 2000+len(sameKeys) {
+				t.Fatalf("use count %v not equal to %v", testHashMap.useCount, 2000+len(sameKeys))
+			}
+			for index := 0; index < 2000; index += 2 {
+				if value, hasKey := testHashMap.Del(key(index)); !hasKey || value != index {
+					t.Fatalf("Del(%#v) = %v, %v not equal to %v", key(index), value, hasKey, index)
+				}
+			}
+			for index := 0; index != 2000; index++ {
+				value, hasKey := testHashMap.Get(key(index))
+				if hasKey != (index%2 == 1) || (hasKey && value != index) {
+					t.Fatalf("Get(%#v) = %v, %v not expected", key(index), value, hasKey)
+				}
+			}
+		})
+	}
+
+	t.Run("persistent", func(t *testing.T) {
+		testHashMap := MakePersistentHashMap[K, int](nil)
+		for index := 0; index != 2000; index++ {
+			testHashMap = testHashMap.Set(key(index), index)
+		}
+		for _, pair := range sameKeys {
+			testHashMap = testHashMap.Set(pair[0], -1).Set(pair[1], -2)
+		}
+		if testHashMap.Len() != 2000+len(sameKeys) {
+			t.Fatalf("Len() %v not equal to %v", testHashMap.Len(), 2000+len(sameKeys))
+		}
+		for index := 0; index != 2000; index++ {
+			if value, hasKey := testHashMap.Get(key(index)); !hasKey || value != index {
+				t.Fatalf("Get(%#v) = %v, %v not equal to %v", key(index), value, hasKey, index)
+			}
+		}
+	})
+
+	t.Run("frozen", func(t *testing.T) {
+		testHashMap := MakeHashMap[K, int]()
+		for index := 0; index != 2000; index++ {
+			testHashMap.Set(key(index), index)
+		}
+		frozen, freezeError := testHashMap.Freeze()
+		if freezeError != nil {
+			t.Fatalf("Freeze() occurs error: %v", freezeError)
+		}
+		for index := 0; index != 2000; index++ {
+			if value, hasKey := frozen.Get(key(index)); !hasKey || value != index {
+				t.Fatalf("Get(%#v) = %v, %v not equal to %v", key(index), value, hasKey, index)
+			}
+		}
+	})
+}
+
+func TestHashMapKeys(t *testing.T) {
+	t.Run("string", func(t *testing.T) {
+		testHashMapKeys(t, func(index int) string {
+			return fmt.Sprintf("key-%v", index)
+		}, nil)
+	})
+	t.Run("struct", func(t *testing.T) {
+		negativeZero := math.Copysign(0, -1)
+		testHashMapKeys(t, func(index int) testPoint {
+			return testPoint{X: float64(index % 7), Name: fmt.Sprint(index / 7), Tags: [2]int8{int8(index), int8(index >> 8)}, Z: complex(float32(index%3), 1)}
+		}, [][2]testPoint{
+			{{X: 0, Name: "zero"}, {X: negativeZero, Name: "zero"}},
+			{{Name: "complex", Z: 0}, {Name: "complex", Z: complex(float32(negativeZero), float32(negativeZero))}},
+		})
+	})
+}
+
+// tttCheck checks key order, parent links and that every leaf is at the same depth, it returns the height of n
+func tttCheck(t *testing.T, n *tttNode[int, int]) int {
+	t.Helper()
+	values, children := n.values(), n.children()
+	if len(values) == 0 {
+		t.Fatalf("node %v has no value", n.getKeyString())
+	}
+	if len(values) == 2 && values[0].k >= values[1].k {
+		t.Fatalf("node %v values out of order", n.getKeyString())
+	}
+	if len(children) == 0 {
+		return 1
+	}
+	if len(children) != len(values)+1 {
+		t.Fatalf("node %v has %v values but %v children", n.getKeyString(), len(values), len(children))
+	}
+	height := 0
+	for index, child := range children {
+		if child.parentNode != n {
+			t.Fatalf("child %v of node %v has a wrong parent", child.getKeyString(), n.getKeyString())
+		}
+		childValues := child.values()
+		if index > 0 && childValues[0].k <= values[index-1].k {
+			t.Fatalf("child %v not greater than value %v", child.getKeyString(), values[index-1].k)
+		}
+		if index < len(values) && childValues[len(childValues)-1].k >= values[index].k {
+			t.Fatalf("child %v not less than value %v", child.getKeyString(), values[index].k)
+		}
+		childHeight := tttCheck(t, child)
+		if index > 0 && childHeight != height {
+			t.Fatalf("children of node %v have heights %v and %v", n.getKeyString(), height, childHeight)
+		}
+		height = childHeight
+	}
+	return height + 1
+}
+
+// TestTTTValidateCheck runs random Set and Del on one bucket, the tree must stay a valid 2-3 tree after every operation
+func TestTTTValidateCheck(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	for round := 0; round != 200; round++ {
+		data := &tttHashMapData[int, int]{
+			buckets: make([]*tttNode[int, int], 1),
+		}
+		expect := make(map[int]int)
+		for index := 0; index != 300; index++ {
+			key := random.Intn(100)
+			if random.Intn(2) == 0 {
+				data.Set(0, &HashValue[int, int]{k: key, v: index})
+				expect[key] = index
+			} else {
+				value, hasKey := data.Del(0, key)
+				expectValue, expectHasKey := expect[key]
+				if hasKey != expectHasKey || value != expectValue {
+					t.Fatalf("Del(%v) = %v, %v not equal to %v, %v", key, value, hasKey, expectValue, expectHasKey)
+				}
+				delete(expect, key)
+			}
+
+			if root := data.buckets[0]; root == nil {
+				if len(expect) != 0 {
+					t.Fatalf("tree is empty but %v keys are expected", len(expect))
+				}
+			} else {
+				if root.parentNode != nil {
+					t.Fatalf("root %v has a parent", root.getKeyString())
+				}
+				root.validateCheck()
+				tttCheck(t, root)
+			}
+			count := 0
+			data.Range(func(*HashValue[int, int]) bool {
+				count++
+				return true
+			})
+			if count != len(expect) {
+				t.Fatalf("Range() visits %v keys not equal to %v", count, len(expect))
+			}
+			for key, value := range expect {
+				if _value, hasKey := data.Get(0, key); !hasKey || _value != value {
+					t.Fatalf("Get(%v) = %v, %v not equal to origin value %v", key, _value, hasKey, value)
+				}
+			}
+		}
+	}
+}
+
+// TestConcurrentHashMapStress runs Set, Get and Del of disjoint key ranges on goroutines, run it with -race
+func TestConcurrentHashMapStress(t *testing.T) {
+	const goroutines, count = 32, 1 << 10
+	for _, backend := range []testBackend{testBackends[0], {
+		name:     "st", // Get splays the bucket, so it takes the write lock
+		allocate: (&stHashMapData[int, int]{}).Allocate,
+	}} {
+		t.Run(backend.name, func(t *testing.T) {
+			testHashMap := MakeConcurrentHashMap(0, WithHashMapData(backend.allocate(8)))
+
+			var waitGroup sync.WaitGroup
+			for goroutine := 0; goroutine != goroutines; goroutine++ {
+				waitGroup.Add(1)
+				go func(base int) {
+					defer waitGroup.Done()
+					for index := 0; index != count; index++ {
+						key := base + index
+						testHashMap.Set(key, key)
+						if value, hasKey := testHashMap.Get(key); !hasKey || value != key {
+							t.Errorf("Get(%v) = %v, %v not equal to origin value %v", key, value, hasKey, key)
+							return
+						}
+						// keys of the other goroutines are read under the same shard locks
+						testHashMap.Get(key ^ count)
+						if index&1 == 1 {
+							if value, hasKey := testHashMap.Del(key); !hasKey || value != key {
+								t.Errorf("Del(%v) = %v, %v not equal to origin value %v", key, value, hasKey, key)
+								return
+							}
+						}
+					}
+					// readers of the whole map race with the writers above
+					testHashMap.Range(func(k, v int) bool {
+						return k == v
+					})
+				}(goroutine * count)
+			}
+			waitGroup.Wait()
+
+			if length, expect := testHashMap.Len(), goroutines*count/2; length != expect {
+				t.Fatalf("Len() %v not equal to %v", length, expect)
+			}
+			testHashMap.Range(func(k, v int) bool {
+				if k != v || (k%count)&1 == 1 {
+					t.Fatalf("Range() visits deleted or wrong key %v value %v", k, v)
+				}
+				return true
+			})
+		})
+	}
+}
+
+// TestLockFreeReadHashMapStress runs lock free readers against writers of disjoint key ranges, run it with -race
+func TestLockFreeReadHashMapStress(t *testing.T) {
+	const readers, writers, count = 8, 8, 1 << 10
+	testHashMap := MakeLockFreeReadHashMap[int, int](4, nil)
+
+	var writeGroup, readGroup sync.WaitGroup
+	var done atomic.Bool
+	for reader := 0; reader != readers; reader++ {
+		readGroup.Add(1)
+		go func(seed int64) {
+			defer readGroup.Done()
+			random := rand.New(rand.NewSource(seed))
+			for !done.Load() {
+				key := random.Intn(writers * count)
+				if value, hasKey := testHashMap.Get(key); hasKey && value != key {
+					t.Errorf("Get(%v) = %v not equal to origin value %v", key, value, key)
+					return
+				}
+			}
+		}(int64(reader))
+	}
+	for writer := 0; writer != writers; writer++ {
+		writeGroup.Add(1)
+		go func(base int) {
+			defer writeGroup.Done()
+			for index := 0; index != count; index++ {
+				key := base + index
+				testHashMap.Set(key, key)
+				if value, hasKey := testHashMap.Get(key); !hasKey || value != key {
+					t.Errorf("Get(%v) = %v, %v not equal to origin value %v", key, value, hasKey, key)
+					return
+				}
+				if index&1 == 1 {
+					if value, hasKey := testHashMap.Del(key); !hasKey || value != key {
+						t.Errorf("Del(%v) = %v, %v not equal to origin value %v", key, value, hasKey, key)
+						return
+					}
+				}
+			}
+		}(writer * count)
+	}
+	writeGroup.Wait()
+	done.Store(true)
+	readGroup.Wait()
+
+	if length, expect := testHashMap.Len(), writers*count/2; length != expect {
+		t.Fatalf("Len() %v not equal to %v", length, expect)
+	}
+	for key := 0; key != writers*count; key++ {
+		if _, hasKey := testHashMap.Get(key); hasKey != ((key%count)&1 == 0) {
+			t.Fatalf("Get(%v) has key %v not expected", key, hasKey)
+		}
+	}
+}
+
+// testKeyValueMap makes count distinct positive keys with their insertion order as values
+func testKeyValueMap(random *rand.Rand, count int) map[int]int {
+	keyValueMap := make(map[int]int, count)
+	for len(keyValueMap) != count {
+		if k := random.Intn(count<<4) + 1; keyValueMap[k] == 0 {
+			keyValueMap[k] = len(keyValueMap) + 1
+		}
+	}
+	return keyValueMap
+}
+
+// TestFrozenHashMap checks every key before and after a serialization round trip
+func TestFrozenHashMap(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+	for _, count := range []int{0, 1, 7, 1000} {
+		keyValueMap := testKeyValueMap(random, count)
+		testHashMap := MakeHashMap[int, int]()
+		for key, value := range keyValueMap {
+			testHashMap.Set(key, value)
+		}
+
+		frozen, freezeError := testHashMap.Freeze()
+		if freezeError != nil {
+			t.Fatalf("Freeze() of %v keys occurs error: %v", count, freezeError)
+		}
+		data, marshalError := frozen.MarshalBinary()
+		if marshalError != nil {
+			t.Fatalf("MarshalBinary() occurs error: %v", marshalError)
+		}
+		loaded, loadError := LoadFrozenHashMap[int, int](data)
+		if loadError != nil {
+			t.Fatalf("LoadFrozenHashMap() occurs error: %v", loadError)
+		}
+
+		for _, frozenHashMap := range []*FrozenHashMap[int, int]{frozen, loaded} {
+			if frozenHashMap.Len() != len(keyValueMap) {
+				t.Fatalf("Len() %v not equal to origin length %v", frozenHashMap.Len(), len(keyValueMap))
+			}
+			for key, value := range keyValueMap {
+				if _value, hasKey := frozenHashMap.Get(key); !hasKey || _value != value {
+					t.Fatalf("Get(%v) = %v, %v not equal to origin value %v", key, _value, hasKey, value)
+				}
+				if _, hasKey := frozenHashMap.Get(-key); hasKey {
+					t.Fatalf("Get(%v) has key not in origin map", -key)
+				}
+			}
+			visited := 0
+			frozenHashMap.Range(func(k, v int) bool {
+				visited++
+				return keyValueMap[k] == v
+			})
+			if visited != len(keyValueMap) {
+				t.Fatalf("Range() visits %v keys not equal to %v", visited, len(keyValueMap))
+			}
+		}
+	}
+}
+
+func TestFrozenHashMapKeys(t *testing.T) {
+	// distinct pointers to equal values are distinct keys
+	pointerHashMap := MakeHashMap[*int, int]()
+	one, another := 1, 1
+	pointerHashMap.Set(&one, 1)
+	pointerHashMap.Set(&another, 2)
+	frozen, freezeError := pointerHashMap.Freeze()
+	if freezeError != nil {
+		t.Fatalf("Freeze() of pointer keys occurs error: %v", freezeError)
+	}
+	if value, hasKey := frozen.Get(&another); !hasKey || value != 2 {
+		t.Fatalf("Get(&another) = %v, %v not equal to 2", value, hasKey)
+	}
+	// pointers are hashed by address, which is meaningless in another process
+	if _, marshalError := frozen.MarshalBinary(); marshalError == nil {
+		t.Fatalf("MarshalBinary() of pointer keys succeeds")
+	}
+	if _, loadError := LoadFrozenHashMap[*int, int](nil); loadError == nil {
+		t.Fatalf("LoadFrozenHashMap() of pointer keys succeeds")
+	}
+
+	// NaN is never equal to itself, so both Set add a key with the same hash64
+	floatHashMap := MakeHashMap[float64, int]()
+	floatHashMap.Set(math.NaN(), 1)
+	floatHashMap.Set(math.NaN(), 2)
+	if _, freezeError := floatHashMap.Freeze(); freezeError == nil {
+		t.Fatalf("Freeze() of keys sharing the same hash64 succeeds")
+	}
+}
+
+// TestPersistentHashMap sets and deletes every key version by version, every old version must stay unchanged
+func TestPersistentHashMap(t *testing.T) {
+	random := rand.New(rand.NewSource(4))
+	for _, hasher := range []Hasher[int]{nil, HasherFunc[int](func(k int, l uint) int {
+		return k & 0xff // keys collide below the first levels
+	})} {
+		keyValueMap := testKeyValueMap(random, 2000)
+		versions := []*PersistentHashMap[int, int]{MakePersistentHashMap[int, int](hasher)}
+		keys := make([]int, 0, len(keyValueMap))
+		for key, value := range keyValueMap {
+			keys = append(keys, key)
+			versions = append(versions, versions[len(versions)-1].Set(key, value))
+		}
+		for _, key := range keys {
+			testHashMap, value, hasKey := versions[len(versions)-1].Del(key)
+			if !hasKey || value != keyValueMap[key] {
+				t.Fatalf("Del(%v) = %v, %v not equal to origin value %v", key, value, hasKey, keyValueMap[key])
+			}
+			versions = append(versions, testHashMap)
+		}
+
+		// version i holds keys[:i] for i <= len(keys), then version len(keys)+j holds keys[j:]
+		for version := 0; version < len(versions); version += 97 {
+			testHashMap := versions[version]
+			low, high := 0, version
+			if version > len(keys) {
+				low, high = version-len(keys), len(keys)
+			}
+			if testHashMap.Len() != high-low {
+				t.Fatalf("version %v Len() %v not equal to %v", version, testHashMap.Len(), high-low)
+			}
+			for index, key := range keys {
+				value, hasKey := testHashMap.Get(key)
+				if hasKey != (low <= index && index < high) || (hasKey && value != keyValueMap[key]) {
+					t.Fatalf("version %v Get(%v) = %v, %v not expected", version, key, value, hasKey)
+				}
+			}
+		}
+		if last := versions[len(versions)-1]; last.Len() != 0 || last.root != nil {
+			t.Fatalf("last version has %v keys left", last.Len())
+		}
+	}
+}
+
+// TestPersistentHashMapHighBits sets keys differing only in the high 32 bits, they must not end up in one collision list
+func TestPersistentHashMapHighBits(t *testing.T) {
+	testHashMap := MakePersistentHashMap[int64, int](nil)
+	for index := 0; index != 20000; index++ {
+		testHashMap = testHashMap.Set(int64(index)<<32, index)
+	}
+	for index := 0; index != 20000; index++ {
+		if value, hasKey := testHashMap.Get(int64(index) << 32); !hasKey || value != index {
+			t.Fatalf("Get(%v) = %v, %v not equal to %v", int64(index)<<32, value, hasKey, index)
+		}
+	}
+	longest := 0
+	var walk func(*hamtNode[int64, int])
+	walk = func(n *hamtNode[int64, int]) {
+		if len(n.collisions) > longest {
+			longest = len(n.collisions)
+		}
+		for _, entry := range n.entries {
+			if entry.child != nil {
+				walk(entry.child)
+			}
+		}
+	}
+	walk(testHashMap.root)
+	if longest > 4 {
+		t.Fatalf("longest collision list has %v keys", longest)
+	}
+}
+
+// TestHashMapSnapshot takes a snapshot every round and keeps changing the origin, every snapshot must stay unchanged
+func TestHashMapSnapshot(t *testing.T) {
+	testHashMaps(t, func(t *testing.T, testHashMap *HashMap[int, int]) {
+		keyValueMap := testKeyValueMap(rand.New(rand.NewSource(5)), 300)
+		keys := make([]int, 0, len(keyValueMap))
+		expect := make(map[int]int, len(keyValueMap))
+		for key, value := range keyValueMap {
+			keys = append(keys, key)
+			testHashMap.Set(key, value)
+			expect[key] = value
+		}
+
+		type snapshotCase struct {
+			snapshot *HashMapSnapshot[int, int]
+			expect   map[int]int
+		}
+		var snapshotCases []snapshotCase
+		for round := 0; round != 8; round++ {
+			snapshotCase := snapshotCase{
+				snapshot: testHashMap.Snapshot(),
+				expect:   make(map[int]int, len(expect)),
+			}
+			for key, value := range expect {
+				snapshotCase.expect[key] = value
+			}
+			snapshotCases = append(snapshotCases, snapshotCase)
+			for index, key := range keys {
+				switch (index + round) % 3 {
+				case 0:
+					testHashMap.Del(key)
+					delete(expect, key)
+				case 1:
+					testHashMap.Set(key, round)
+					expect[key] = round
+				}
+			}
+		}
+		checkHashMap(t, testHashMap, expect)
+
+		for round, snapshotCase := range snapshotCases {
+			if snapshotCase.snapshot.Len() != len(snapshotCase.expect) {
+				t.Fatalf("round %v Len() %v not equal to %v", round, snapshotCase.snapshot.Len(), len(snapshotCase.expect))
+			}
+			for _, key := range keys {
+				value, hasKey := snapshotCase.snapshot.Get(key)
+				expectValue, expectHasKey := snapshotCase.expect[key]
+				if hasKey != expectHasKey || value != expectValue {
+					t.Fatalf("round %v Get(%v) = %v, %v not equal to %v, %v", round, key, value, hasKey, expectValue, expectHasKey)
+				}
+			}
+			visited := make(map[int]int, len(snapshotCase.expect))
+			snapshotCase.snapshot.Range(func(k, v int) bool {
+				if _, hasKey := visited[k]; hasKey {
+					t.Fatalf("round %v Range() visits key %v twice", round, k)
+				}
+				visited[k] = v
+				return true
+			})
+			if len(visited) != len(snapshotCase.expect) {
+				t.Fatalf("round %v Range() visits %v keys not equal to %v", round, len(visited), len(snapshotCase.expect))
+			}
+			for key, value := range visited {
+				if snapshotCase.expect[key] != value {
+					t.Fatalf("round %v Range() visits key %v with value %v not equal to %v", round, key, value, snapshotCase.expect[key])
+				}
+			}
+		}
+	})
+}
+
+// TestSnapshotter checks which backends share buckets with a snapshot, and that Del of a missing key copies nothing
+func TestSnapshotter(t *testing.T) {
+	sharing := map[string]bool{"dll": true, "bst": true, "avlt": true, "treeify": true, "rbt": true, "skipList": true, "tp": true, "ttt": true, "btree": true}
+	for _, backend := range testBackends {
+		if _, ok := backend.allocate(8).(snapshotter[int, int]); ok != sharing[backend.name] {
+			t.Fatalf("%v implements snapshotter %v not equal to %v", backend.name, ok, sharing[backend.name])
+		}
+	}
+
+	data := &avltHashMapData[int, int]{
+		buckets: make([]*avltNode[int, int], 8),
+	}
+	data.Set(0, &HashValue[int, int]{k: 1, v: 1})
+	data.Snapshot()
+	if _, hasKey := data.Del(0, 2); hasKey || !data.cow.shared {
+		t.Fatalf("Del() of a missing key copies the shared buckets")
+	}
+	if _, hasKey := data.Del(0, 1); !hasKey || data.cow.shared {
+		t.Fatalf("Del() of a key leaves the buckets shared")
+	}
+}
+
+// TestHashMapIterator checks iterators alone, interleaved, over a Snapshot and under Set and Del of the HashMap.
+// Without any change every key is visited exactly once, with changes keys may be skipped or repeated
+// but only keys in the HashMap at some moment of the iteration are visited and the HashMap stays consistent
+func TestHashMapIterator(t *testing.T) {
+	testHashMaps(t, func(t *testing.T, testHashMap *HashMap[int, int]) {
+		keyValueMap := testKeyValueMap(rand.New(rand.NewSource(6)), 300)
+		for key, value := range keyValueMap {
+			testHashMap.Set(key, value)
+		}
+
+		visitAll := func(name string, iterator *HashMapIterator[int, int], op func(k, v int)) map[int]int {
+			visited := make(map[int]int, len(keyValueMap))
+			for iterator.Next() {
+				if _, hasKey := visited[iterator.Key()]; hasKey {
+					t.Fatalf("%v visits key %v twice", name, iterator.Key())
+				}
+				visited[iterator.Key()] = iterator.Value()
+				op(iterator.Key(), iterator.Value())
+			}
+			if iterator.Next() {
+				t.Fatalf("%v Next() returns true after the last key", name)
+			}
+			return visited
+		}
+		checkVisited := func(name string, visited map[int]int) {
+			if len(visited) != len(keyValueMap) {
+				t.Fatalf("%v visits %v keys not equal to %v", name, len(visited), len(keyValueMap))
+			}
+			for key, value := range keyValueMap {
+				if visited[key] != value {
+					t.Fatalf("%v visits key %v with value %v not equal to origin value %v", name, key, visited[key], value)
+				}
+			}
+		}
+
+		checkVisited("iterator", visitAll("iterator", testHashMap.Iterator(), func(k, v int) {}))
+
+		// two iterators at different speed
+		slowVisited, fastVisited := make(map[int]int, len(keyValueMap)), make(map[int]int, len(keyValueMap))
+		slowIterator, fastIterator := testHashMap.Iterator(), testHashMap.Iterator()
+		for slowNext, fastNext := true, true; slowNext || fastNext; {
+			if slowNext = slowIterator.Next(); slowNext {
+				slowVisited[slowIterator.Key()] = slowIterator.Value()
+			}
+			for step := 0; step != 2 && fastNext; step++ {
+				if fastNext = fastIterator.Next(); fastNext {
+					fastVisited[fastIterator.Key()] = fastIterator.Value()
+				}
+			}
+		}
+		checkVisited("slow iterator", slowVisited)
+		checkVisited("fast iterator", fastVisited)
+
+		// a snapshot keeps its keys while the origin is changed during the iteration
+		snapshot := testHashMap.Snapshot()
+		checkVisited("snapshot iterator", visitAll("snapshot iterator", snapshot.Iterator(), func(k, v int) {
+			testHashMap.Set(-k, v)
+			testHashMap.Del(k)
+		}))
+		expect := make(map[int]int, len(keyValueMap))
+		for key, value := range keyValueMap {
+			expect[-key] = value
+		}
+		checkHashMap(t, testHashMap, expect)
+
+		// every visited key moves to its mirror key and the mirror key is deleted once visited,
+		// growing, shrinking and evacuating during the iteration
+		steps := 0
+		for iterator := testHashMap.Iterator(); iterator.Next(); steps++ {
+			key, value := iterator.Key(), iterator.Value()
+			originKey := key
+			if originKey < 0 {
+				originKey = -originKey
+			}
+			if value != keyValueMap[originKey] {
+				t.Fatalf("iterator visits key %v with value %v never set", key, value)
+			}
+			// splay tree buckets repeat the most, every Set and Del moves the nodes around the iterator
+			if steps > len(keyValueMap)<<6 {
+				t.Fatalf("iterator visits %v keys while only %v keys are ever set", steps, len(keyValueMap)<<1)
+			}
+			_, expectHasKey := expect[key]
+			if _, hasKey := testHashMap.Del(key); hasKey != expectHasKey {
+				t.Fatalf("Del(%v) of a visited key has key %v not equal to %v", key, hasKey, expectHasKey)
+			}
+			delete(expect, key)
+			if key < 0 && expectHasKey {
+				testHashMap.Set(-key, value)
+				expect[-key] = value
+			}
+		}
+		checkHashMap(t, testHashMap, expect)
+	})
+}
+
+// benchmarkMap is the part of HashMap timed by the benchmarks
+type benchmarkMap interface {
+	Set(int, int) bool
+	Get(int) (int, bool)
+	Del(int) (int, bool)
+}
+
+// builtinMap is the baseline of the benchmarks
+type builtinMap map[int]int
+
+func (m builtinMap) Set(k, v int) bool {
+	m[k] = v
+	return true
+}
+
+func (m builtinMap) Get(k int) (int, bool) {
+	v, ok := m[k]
+	return v, ok
+}
+
+func (m builtinMap) Del(k int) (int, bool) {
+	v, ok := m[k]
+	delete(m, k)
+	return v, ok
+}
+
+const benchmarkCount = 1 << 16
+
+// benchmarkMaps times Set, Get of hit and miss keys and Del of benchmarkCount random keys in a fresh map
+func benchmarkMaps(b *testing.B, makeMap func() benchmarkMap) {
+	keys := rand.New(rand.NewSource(0)).Perm(benchmarkCount << 2)[:benchmarkCount]
+	fill := func() benchmarkMap {
+		benchMap := makeMap()
+		for index, key := range keys {
+			benchMap.Set(key, index)
+		}
+		return benchMap
+	}
+
+	b.Run("Set", func(b *testing.B) {
+		var benchMap benchmarkMap
+		for index := 0; index != b.N; index++ {
+			if index%benchmarkCount == 0 {
+				b.StopTimer()
+				benchMap = makeMap()
+				b.StartTimer()
+			}
+			benchMap.Set(keys[index%benchmarkCount], index)
+		}
+	})
+	b.Run("Get", func(b *testing.B) {
+		benchMap := fill()
+		b.ResetTimer()
+		for index := 0; index != b.N; index++ {
+			key := keys[index%benchmarkCount]
+			if index&1 == 1 {
+				key = -key - 1 // miss
+			}
+			benchMap.Get(key)
+		}
+	})
+	b.Run("Del", func(b *testing.B) {
+		var benchMap benchmarkMap
+		for index := 0; index != b.N; index++ {
+			if index%benchmarkCount == 0 {
+				b.StopTimer()
+				benchMap = fill()
+				b.StartTimer()
+			}
+			benchMap.Del(keys[index%benchmarkCount])
+		}
+	})
+}
+
+// BenchmarkHashMap compares every backend with the default load factor against the builtin map
+func BenchmarkHashMap(b *testing.B) {
+	const size = DEFAULT_HASH_MAP_SIZE >> 7
+	b.Run("builtin", func(b *testing.B) {
+		benchmarkMaps(b, func() benchmarkMap {
+			return builtinMap{}
+		})
+	})
+	for _, backend := range testBackends {
+		backend := backend
+		b.Run(backend.name, func(b *testing.B) {
+			benchmarkMaps(b, func() benchmarkMap {
+				return MakeHashMap(WithHashMapData(backend.allocate(size)))
+			})
+		})
+	}
+}
+
+// BenchmarkHashMapSkewedGet 以 Zipf 分布访问少量热点键，每个桶内保留 loadFactor 个左右的键
+func BenchmarkHashMapSkewedGet(b *testing.B) {
+	const (
+		size       = DEFAULT_HASH_MAP_SIZE >> 10
+		loadFactor = 256
+	)
+	keys := rand.New(rand.NewSource(0)).Perm(benchmarkCount << 2)[:benchmarkCount]
+	for _, backend := range []testBackend{
+		{name: "avlt", allocate: (&avltHashMapData[int, int]{}).Allocate},
+		{name: "st", allocate: (&stHashMapData[int, int]{}).Allocate},
+		{name: "tp", allocate: (&tpHashMapData[int, int]{}).Allocate},
+	} {
+		backend := backend
+		b.Run(backend.name, func(b *testing.B) {
+			benchHashMap := MakeHashMap(WithHashMapData(backend.allocate(size)), WithHashMapLoadFactor[int, int](loadFactor))
+			for index, key := range keys {
+				benchHashMap.Set(key, index)
+			}
+			zipf := rand.NewZipf(rand.New(rand.NewSource(0)), 1.1, 1, benchmarkCount-1)
+			b.ResetTimer()
+			for index := 0; index != b.N; index++ {
+				benchHashMap.Get(keys[zipf.Uint64()])
+			}
+		})
+	}
+}